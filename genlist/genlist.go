@@ -0,0 +1,322 @@
+//go:build go1.18
+
+/* genlist is a version of tslist which uses type parameters for the payload instead of interface{}.  It only has tslist's core API: New, FromSlice, Len, Head, Tail, Append, PushBack, PushFront, ToSlice, RemoveMarked and DebugPrint on List, and Value, Next, Prev, RemoveMark, ToRemove, Remove, InsertAfter and InsertBefore on Element.  These behave the same as in tslist. */
+package genlist
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+/* List represents the list itself. */
+type List[T any] struct {
+	head *Element[T]  /* First element in list */
+	tail *Element[T]  /* Last element in list */
+	m    sync.RWMutex /* List-wide synchronization lock */
+	size int          /* Number of elements in list */
+}
+
+/* Len returns the length of l in O(1) time. */
+func (l *List[T]) Len() int {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	return l.size
+}
+
+/* Make a new list */
+func New[T any]() *List[T] {
+	l := &List[T]{}
+	return l
+}
+
+/* FromSlice makes a new list holding the values in vs, in order. */
+func FromSlice[T any](vs []T) *List[T] {
+	l := New[T]()
+	for _, v := range vs {
+		l.Append(v)
+	}
+	return l
+}
+
+/* Head returns the first element of the list not marked for removal, or nil if there is none. */
+func (l *List[T]) Head() *Element[T] {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	/* Holding the list lock keeps the links still while we skip. */
+	return live(l.head)
+}
+
+/* Tail returns the last element of the list not marked for removal, or nil if there is none. */
+func (l *List[T]) Tail() *Element[T] {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	return liveBack(l.tail)
+}
+
+/* Append a value to the list and return the generated Element in O(1) time. */
+func (l *List[T]) Append(v T) *Element[T] {
+	/* Make an element for the Value. */
+	e := &Element[T]{value: v, l: l}
+	l.m.Lock()
+	defer l.m.Unlock()
+	/* Append the element to the tail. */
+	l.insertAfter(e, l.tail)
+	return e
+}
+
+/* PushBack is an alias for Append. */
+func (l *List[T]) PushBack(v T) *Element[T] {
+	return l.Append(v)
+}
+
+/* PushFront prepends a value to the list and returns the generated Element in O(1) time. */
+func (l *List[T]) PushFront(v T) *Element[T] {
+	/* Make an element for the Value. */
+	e := &Element[T]{value: v, l: l}
+	l.m.Lock()
+	defer l.m.Unlock()
+	/* Put the element before the head. */
+	l.insertAfter(e, nil)
+	return e
+}
+
+/* insertAfter links e into the list just after at, or at the front of the list if at is nil.  The list must be write-locked by the caller.  e mustn't yet be reachable from the list. */
+func (l *List[T]) insertAfter(e, at *Element[T]) {
+	/* Lock the element before and the element after. */
+	next := l.head
+	if at != nil {
+		at.m.Lock()
+		defer at.m.Unlock()
+		next = at.next
+	}
+	if next != nil {
+		next.m.Lock()
+		defer next.m.Unlock()
+	}
+	/* Link in the element on both sides. */
+	e.prev = at
+	e.next = next
+	if at == nil {
+		l.head = e
+	} else {
+		at.next = e
+	}
+	if next == nil {
+		l.tail = e
+	} else {
+		next.prev = e
+	}
+	l.size++
+}
+
+/* RemoveMarked sweeps through the list and calls Remove() on each element that is marked for removal.  Frequent additions to the list and scheduled removals may cause this to take a while.  It can be run asnychronously by wrapping it in a goroutine.  This runs in O(n) time, but not in a good way, and could probably use a re-write.  (hint, hint, people who found this on github).  */
+func (l *List[T]) RemoveMarked() {
+	/* Keep trying until we get a clean sweep */
+	for done := false; !done; {
+		done = true
+		l.m.RLock()
+		e := l.head
+		l.m.RUnlock()
+		/* Iterate through list, remove marked elements. */
+		for e != nil {
+			/* Next() skips marked elements, so walk the raw links,
+			grabbing the next one before e is unlinked. */
+			e.m.RLock()
+			next, gone := e.next, e.removed
+			e.m.RUnlock()
+			/* Someone else removed e, so its links are gone.  Start
+			over. */
+			if gone {
+				done = false
+				break
+			}
+			if e.ToRemove() {
+				e.Remove()
+				done = false
+			}
+			e = next
+		}
+	}
+}
+
+/* ToSlice returns the values of the elements in the list which aren't marked for removal, in order.  The list is read-locked while the slice is built, so it's a point-in-time snapshot with respect to insertions and removals, but not to concurrent calls to RemoveMark.  An empty list yields an empty, non-nil slice. */
+func (l *List[T]) ToSlice() []T {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	vs := make([]T, 0, l.size)
+	for e := live(l.head); e != nil; e = live(e.next) {
+		vs = append(vs, e.Value())
+	}
+	return vs
+}
+
+/* live returns e or, if e is marked for removal, the first element after it which isn't.  The list must be locked by the caller, which keeps the links from changing. */
+func live[T any](e *Element[T]) *Element[T] {
+	for e != nil && e.ToRemove() {
+		e = e.next
+	}
+	return e
+}
+
+/* liveBack is like live, but searches towards the head of the list. */
+func liveBack[T any](e *Element[T]) *Element[T] {
+	for e != nil && e.ToRemove() {
+		e = e.prev
+	}
+	return e
+}
+
+/* DebugPrint prints every element in the list to stdout.  This is meant for debugging purposes.  Production code should probably implement this better.  If w is not nil, the list will be output to w instead of stdout.  This is meant for easy diffing of two Lists. */
+func (l *List[T]) DebugPrint(w io.Writer) {
+	/* Default to stdout */
+	if w == nil {
+		w = os.Stdout
+	}
+	for e := l.Head(); e != nil; e = e.Next() {
+		w.Write([]byte(fmt.Sprintf("[Element %#v]"+
+			"[Value (%T) %#v]\n", e, e.Value(), e.Value())))
+	}
+}
+
+/* Element represents a list element. */
+type Element[T any] struct {
+	value   T            /* Payload */
+	remove  bool         /* Tag to mark element for removal */
+	removed bool         /* Prevents double-removal */
+	m       sync.RWMutex /* Synchronization lock */
+	l       *List[T]     /* Pointer to the parent list */
+	next    *Element[T]  /* Next item in list */
+	prev    *Element[T]  /* Previous item in list */
+}
+
+/* Value returns an element's Value */
+func (e *Element[T]) Value() T {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.value
+}
+
+/* Next returns a pointer to the next Element in the list, skipping elements marked for removal.  Only one element is locked at a time, so walking past a long run of marked elements doesn't pile up read locks. */
+func (e *Element[T]) Next() *Element[T] {
+	e.m.RLock()
+	next := e.next
+	e.m.RUnlock()
+	for next != nil {
+		next.m.RLock()
+		/* Check the mark under the same lock as the link. */
+		if !next.remove {
+			next.m.RUnlock()
+			return next
+		}
+		n := next.next
+		next.m.RUnlock()
+		next = n
+	}
+	return nil
+}
+
+/* Prev returns a pointer to the previous Element in the list, skipping elements marked for removal.  As with Next(), only one element is locked at a time; holding e's lock while locking its predecessor would also be out of order with Remove(). */
+func (e *Element[T]) Prev() *Element[T] {
+	e.m.RLock()
+	prev := e.prev
+	e.m.RUnlock()
+	for prev != nil {
+		prev.m.RLock()
+		/* Check the mark under the same lock as the link. */
+		if !prev.remove {
+			prev.m.RUnlock()
+			return prev
+		}
+		p := prev.prev
+		prev.m.RUnlock()
+		prev = p
+	}
+	return nil
+}
+
+/* RemoveMark marks an element for removal.  The element will not actually be removed, but it'll be transparently ignored by Next().  This saves a potentially costly exclusive lock on the list and up to three elements at a cost of more expensive traversal (which uses shared locks).  List's RemoveMarked function will delete all such marked elements. */
+func (e *Element[T]) RemoveMark() {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.remove = true
+}
+
+/* ToRemove indicates whether an element is marked for removal. */
+func (e *Element[T]) ToRemove() bool {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.remove
+}
+
+/* Remove an element.  Afterwards e is a detached node: its Next and Prev return nil. */
+func (e *Element[T]) Remove() {
+	/* Lock the list in case it's the head or tail. */
+	e.l.m.Lock()
+	defer e.l.m.Unlock()
+	/* Don't double-remove.  removed is only set with the list locked,
+	so checking it here means concurrent Removes can't both pass. */
+	if e.removed {
+		return
+	}
+	/* Lock the previous element, this element, and the next. */
+	if e.prev != nil {
+		e.prev.m.Lock()
+		defer e.prev.m.Unlock()
+	}
+	e.m.Lock()
+	defer e.m.Unlock()
+	if e.next != nil {
+		e.next.m.Lock()
+		defer e.next.m.Unlock()
+	}
+	/* Mark the removal, decrase the element count. */
+	e.removed = true
+	e.l.size--
+	/* Unlink it, fixing up the head and tail if it's at either end. */
+	switch {
+	case nil == e.prev && e.next == nil: /* Only item, empty the list. */
+		e.l.head = nil
+		e.l.tail = nil
+	case e.prev == nil: /* Head, the next element becomes the new head. */
+		e.l.head = e.next
+		e.next.prev = nil
+	case e.next == nil: /* Tail, the previous element becomes the new tail. */
+		e.l.tail = e.prev
+		e.prev.next = nil
+	default: /* Internal element, unlink it from both sides. */
+		e.prev.next = e.next
+		e.next.prev = e.prev
+	}
+	/* A removed element is a detached node; it has no neighbors. */
+	e.next = nil
+	e.prev = nil
+}
+
+/* InsertAfter inserts a value into the list just after e and returns the generated Element in O(1) time.  If e has been removed, InsertAfter returns nil. */
+func (e *Element[T]) InsertAfter(v T) *Element[T] {
+	/* Lock the list first, same as Remove. */
+	e.l.m.Lock()
+	defer e.l.m.Unlock()
+	if e.removed {
+		return nil
+	}
+	n := &Element[T]{value: v, l: e.l}
+	e.l.insertAfter(n, e)
+	return n
+}
+
+/* InsertBefore inserts a value into the list just before e and returns the generated Element in O(1) time.  If e has been removed, InsertBefore returns nil. */
+func (e *Element[T]) InsertBefore(v T) *Element[T] {
+	/* Lock the list first, same as Remove. */
+	e.l.m.Lock()
+	defer e.l.m.Unlock()
+	if e.removed {
+		return nil
+	}
+	n := &Element[T]{value: v, l: e.l}
+	/* Locks e.prev then e, in list order. */
+	e.l.insertAfter(n, e.prev)
+	return n
+}
@@ -0,0 +1,62 @@
+//go:build go1.18
+
+package genlist
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	l := New[int]()
+	l.Append(2)
+	l.PushFront(1)
+	l.Tail().InsertAfter(4)
+	l.Tail().InsertBefore(3)
+	if got := fmt.Sprint(l.ToSlice()); got != "[1 2 3 4]" {
+		t.Fatalf("got %v, want [1 2 3 4]", got)
+	}
+	/* Values come back typed, no assertion needed. */
+	sum := 0
+	for e := l.Head(); e != nil; e = e.Next() {
+		sum += e.Value()
+	}
+	if sum != 10 {
+		t.Fatalf("sum: got %v, want 10", sum)
+	}
+	l.Head().RemoveMark()
+	l.Tail().Remove()
+	l.RemoveMarked()
+	if got := fmt.Sprint(l.ToSlice()); got != "[2 3]" || l.Len() != 2 {
+		t.Fatalf("got %v (Len %v), want [2 3]", got, l.Len())
+	}
+	if s := FromSlice([]string{"a", "b"}); s.Tail().Prev().Value() != "a" {
+		t.Fatalf("FromSlice: wrong order")
+	}
+}
+
+func TestHeadTailMarked(t *testing.T) {
+	l := FromSlice([]int{1, 2, 3})
+	l.Head().RemoveMark()
+	l.Tail().RemoveMark()
+	if l.Head().Value() != 2 || l.Tail().Value() != 2 {
+		t.Fatalf("got %v and %v, want 2", l.Head().Value(), l.Tail().Value())
+	}
+	l.Head().RemoveMark()
+	if l.Head() != nil || l.Tail() != nil {
+		t.Fatalf("Head or Tail of all-marked list not nil")
+	}
+}
+
+func TestRemoveDetaches(t *testing.T) {
+	l := FromSlice([]int{1, 2, 3})
+	e := l.Head().Next()
+	e.Remove()
+	if e.Next() != nil || e.Prev() != nil {
+		t.Fatalf("removed element still has neighbors")
+	}
+	e.Remove()
+	if got := fmt.Sprint(l.ToSlice()); got != "[1 3]" || l.Len() != 2 {
+		t.Fatalf("got %v (Len %v), want [1 3]", got, l.Len())
+	}
+}
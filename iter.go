@@ -0,0 +1,31 @@
+//go:build go1.23
+
+package tslist
+
+import "iter"
+
+/* All returns an iterator over the values of the elements in the list, from head to tail, for use with range.  As with Next(), elements marked for removal are skipped.  No locks are held while the loop body runs, so it's safe to modify the list from within the loop, subject to the same caveat as Range, or to break out of it early. */
+func (l *List) All() iter.Seq[interface{}] {
+	return func(yield func(interface{}) bool) {
+		for e := l.Head(); e != nil; {
+			next := e.Next()
+			if !yield(e.Value()) {
+				return
+			}
+			e = step(l, e, next, false)
+		}
+	}
+}
+
+/* Elements is like All, but iterates over the elements themselves. */
+func (l *List) Elements() iter.Seq[*Element] {
+	return func(yield func(*Element) bool) {
+		for e := l.Head(); e != nil; {
+			next := e.Next()
+			if !yield(e) {
+				return
+			}
+			e = step(l, e, next, false)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+//go:build go1.23
+
+package tslist
+
+import "testing"
+
+func TestAll(t *testing.T) {
+	l := ints(4)
+	n := 0
+	for v := range l.All() {
+		n++
+		if v == 2 {
+			break
+		}
+	}
+	if n != 2 {
+		t.Fatalf("visited %v before break, want 2", n)
+	}
+	/* Breaking out mustn't leave anything locked. */
+	l.Append(5)
+	for e := range l.Elements() {
+		if e.Value() == 3 {
+			e.RemoveMark()
+		}
+	}
+	checkOrder(t, l, "[1 2 4 5]")
+}
@@ -0,0 +1,37 @@
+package tslist
+
+import "sync"
+
+/* elementPool holds Elements for reuse by Queue and Stack.  They never let callers see their Elements, so once one's been popped nothing else can be holding it.  Elements from plain Lists are never pooled, as callers may keep them after they're removed. */
+var elementPool = sync.Pool{New: func() interface{} { return new(Element) }}
+
+/* pushPooled adds v to the front or back of l in an Element from elementPool.  l's bound, if any, is ignored. */
+func (l *List) pushPooled(v interface{}, front bool) {
+	e := elementPool.Get().(*Element)
+	e.value = v
+	e.l = l
+	l.m.Lock()
+	defer l.unlock()
+	at := l.tail
+	if front {
+		at = nil
+	}
+	l.insertAfter(e, at)
+}
+
+/* popPooled removes the first element in l not marked for removal, returns its value, and puts the element back in elementPool.  If there is no such element, ok is false.  Nothing else may be holding l's elements. */
+func (l *List) popPooled() (v interface{}, ok bool) {
+	l.m.Lock()
+	e := live(l.head)
+	if e == nil {
+		l.unlock()
+		return nil, false
+	}
+	l.unlink(e)
+	l.unlock()
+	/* Nobody else has e now, so there's no need to lock it. */
+	v = e.value
+	*e = Element{}
+	elementPool.Put(e)
+	return v, true
+}
@@ -0,0 +1,26 @@
+package tslist
+
+/* Queue is a thread-safe FIFO queue backed by a List, for callers who don't need to deal with Elements.  As nobody else sees its Elements, they're recycled, so a busy Queue makes less garbage than a List. */
+type Queue struct {
+	l *List
+}
+
+/* NewQueue makes a new, empty Queue. */
+func NewQueue() *Queue {
+	return &Queue{l: New()}
+}
+
+/* Enqueue adds v to the back of the queue. */
+func (q *Queue) Enqueue(v interface{}) {
+	q.l.pushPooled(v, false)
+}
+
+/* Dequeue removes and returns the value at the front of the queue.  If the queue is empty, ok is false. */
+func (q *Queue) Dequeue() (v interface{}, ok bool) {
+	return q.l.popPooled()
+}
+
+/* Len returns the number of values in the queue. */
+func (q *Queue) Len() int {
+	return q.l.Len()
+}
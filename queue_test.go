@@ -0,0 +1,98 @@
+package tslist
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestQueue(t *testing.T) {
+	q := NewQueue()
+	if v, ok := q.Dequeue(); ok || v != nil {
+		t.Fatalf("empty Dequeue: got %v, %v, want nil, false", v, ok)
+	}
+	for i := 1; i <= 3; i++ {
+		q.Enqueue(i)
+	}
+	if q.Len() != 3 {
+		t.Fatalf("Len: got %v, want 3", q.Len())
+	}
+	for i := 1; i <= 3; i++ {
+		if v, ok := q.Dequeue(); !ok || v != i {
+			t.Fatalf("got %v, %v, want %v, true", v, ok, i)
+		}
+	}
+	if _, ok := q.Dequeue(); ok || q.Len() != 0 {
+		t.Fatalf("queue not empty after dequeueing everything")
+	}
+
+	/* Concurrent producers and consumers see every value once. */
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		seen = make(map[interface{}]bool)
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < bulk; j++ {
+				q.Enqueue(i*bulk + j)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				v, ok := q.Dequeue()
+				if !ok {
+					return
+				}
+				mu.Lock()
+				if seen[v] {
+					t.Errorf("%v dequeued twice", v)
+				}
+				seen[v] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(seen) != 4*bulk {
+		t.Fatalf("dequeued %v values, want %v", len(seen), 4*bulk)
+	}
+
+	/* Recycled elements don't bring anything with them. */
+	q.Enqueue(1)
+	q.Dequeue()
+	q.Enqueue(2)
+	if err := q.l.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if e := q.l.Head(); e.ToRemove() || e.Next() != nil || e.Prev() != nil {
+		t.Fatalf("recycled element has leftover state")
+	}
+	if v, _ := q.Dequeue(); v != 2 {
+		t.Fatalf("got %v, want 2", v)
+	}
+}
+
+func BenchmarkQueueChurn(b *testing.B) {
+	b.ReportAllocs()
+	q := NewQueue()
+	for i := 0; i < b.N; i++ {
+		q.Enqueue(i)
+		q.Dequeue()
+	}
+}
+
+func BenchmarkListChurn(b *testing.B) {
+	b.ReportAllocs()
+	l := New()
+	for i := 0; i < b.N; i++ {
+		l.Append(i)
+		l.PopFront()
+	}
+}
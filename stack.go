@@ -0,0 +1,26 @@
+package tslist
+
+/* Stack is a thread-safe LIFO stack backed by a List, for callers who don't need to deal with Elements.  Like Queue, it recycles its Elements. */
+type Stack struct {
+	l *List
+}
+
+/* NewStack makes a new, empty Stack. */
+func NewStack() *Stack {
+	return &Stack{l: New()}
+}
+
+/* Push adds v to the top of the stack. */
+func (s *Stack) Push(v interface{}) {
+	s.l.pushPooled(v, true)
+}
+
+/* Pop removes and returns the value at the top of the stack.  If the stack is empty, ok is false. */
+func (s *Stack) Pop() (v interface{}, ok bool) {
+	return s.l.popPooled()
+}
+
+/* Len returns the number of values in the stack. */
+func (s *Stack) Len() int {
+	return s.l.Len()
+}
@@ -0,0 +1,47 @@
+package tslist
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestStack(t *testing.T) {
+	s := NewStack()
+	if v, ok := s.Pop(); ok || v != nil {
+		t.Fatalf("empty Pop: got %v, %v, want nil, false", v, ok)
+	}
+	for i := 1; i <= 3; i++ {
+		s.Push(i)
+	}
+	if s.Len() != 3 {
+		t.Fatalf("Len: got %v, want 3", s.Len())
+	}
+	for i := 3; i >= 1; i-- {
+		if v, ok := s.Pop(); !ok || v != i {
+			t.Fatalf("got %v, %v, want %v, true", v, ok, i)
+		}
+	}
+	if _, ok := s.Pop(); ok || s.Len() != 0 {
+		t.Fatalf("stack not empty after popping everything")
+	}
+
+	/* Concurrent pushes and pops balance out. */
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < bulk; j++ {
+				s.Push(j)
+				if _, ok := s.Pop(); !ok {
+					t.Errorf("Pop after Push failed")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if s.Len() != 0 {
+		t.Fatalf("Len: got %v, want 0", s.Len())
+	}
+}
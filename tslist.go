@@ -2,92 +2,1685 @@
 package tslist
 
 import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"sync"
+	"sync/atomic"
+	"unsafe"
 )
 
+/* ErrFull is returned when adding to a list made with NewBounded which is already at capacity. */
+var ErrFull = errors.New("list full")
+
+/* ErrIndex is returned when an index is out of range. */
+var ErrIndex = errors.New("index out of range")
+
+/* ErrModified is returned by Iterator.Err when the list changed during iteration. */
+var ErrModified = errors.New("list modified during iteration")
+
 /* List represents the list itself. */
 type List struct {
-	head *Element     /* First element in list */
-	tail *Element     /* Last element in list */
-	m    sync.RWMutex /* List-wide synchronization lock */
-	size int          /* Number of elements in list */
+	marked  int64                  /* Number of marked elements, first for atomic's alignment */
+	version uint64                 /* Bumped on structural changes, also for alignment */
+	head    *Element               /* First element in list */
+	tail    *Element               /* Last element in list */
+	m       sync.RWMutex           /* List-wide synchronization lock */
+	size    int                    /* Number of elements in list */
+	max     int                    /* Maximum size, or 0 for unbounded */
+	ring    bool                   /* Appending to a full list evicts the head */
+	c       *sync.Cond             /* Signaled when elements are added or removed */
+	hooks   []func(ev ChangeEvent) /* Registered with OnChange */
+	hm      sync.Mutex             /* Protects events and firing */
+	events  []ChangeEvent          /* Not yet passed to hooks */
+	firing  bool                   /* Some goroutine is calling hooks */
+}
+
+/* Len returns the length of l in O(1) time. */
+func (l *List) Len() int {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	return l.size
+}
+
+/* IsEmpty returns true if the list has no elements, including elements marked for removal.  It looks at the list itself rather than the count returned by Len. */
+func (l *List) IsEmpty() bool {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	return l.head == nil
+}
+
+/* LiveLen returns the number of elements in l which aren't marked for removal, i.e. the number traversal with Next() will visit, in O(1) time.  Unlike Len, this drops as soon as RemoveMark is called. */
+func (l *List) LiveLen() int {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	return l.size - int(atomic.LoadInt64(&l.marked))
+}
+
+/* Stats returns the number of elements in the list, how many of them are marked for removal, and how many aren't.  Unlike Len and LiveLen, the elements are actually counted, in O(n) time. */
+func (l *List) Stats() (total, marked, live int) {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	for e := l.head; e != nil; e = e.next {
+		total++
+		if e.ToRemove() {
+			marked++
+		}
+	}
+	return total, marked, total - marked
+}
+
+/* Make a new list */
+func New() *List {
+	l := &List{}
+	return l
+}
+
+/* NewBounded makes a new list which holds at most max elements, including those marked for removal.  Once full, TryAppend and TryPushFront return ErrFull and other insertions return nil.  A max of 0 or less means the list is unbounded, as with New. */
+func NewBounded(max int) *List {
+	l := &List{max: max}
+	return l
+}
+
+/* NewRing makes a new list which holds at most max elements, like NewBounded, except that appending to it with Append, TryAppend, or PushBack when it's full removes the head to make room, like a ring buffer.  Use PushRing to get the removed value.  Other insertions into a full ring fail as with a bounded list.  A max of 0 or less means the list is unbounded and never evicts. */
+func NewRing(max int) *List {
+	return &List{max: max, ring: true}
+}
+
+/* FromSlice makes a new list holding the values in vs, in order. */
+func FromSlice(vs []interface{}) *List {
+	l := New()
+	for _, v := range vs {
+		l.Append(v)
+	}
+	return l
+}
+
+/* Head returns the first element of the list not marked for removal, or nil if there is none. */
+func (l *List) Head() *Element {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	/* Holding the list lock keeps the links still while we skip. */
+	return live(l.head)
+}
+
+/* Tail returns the last element of the list not marked for removal, or nil if there is none. */
+func (l *List) Tail() *Element {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	return liveBack(l.tail)
+}
+
+/* Front returns the value of the first element of the list not marked for removal and true, or false if there's no such element.  Nothing is removed. */
+func (l *List) Front() (interface{}, bool) {
+	if e := l.Head(); e != nil {
+		return e.Value(), true
+	}
+	return nil, false
+}
+
+/* Back is like Front, but for the last element. */
+func (l *List) Back() (interface{}, bool) {
+	if e := l.Tail(); e != nil {
+		return e.Value(), true
+	}
+	return nil, false
+}
+
+/* Append a value to the list and return the generated Element in O(1) time.  If the list is bounded and full, Append returns nil. */
+func (l *List) Append(v interface{}) *Element {
+	e, _ := l.TryAppend(v)
+	return e
+}
+
+/* TryAppend is like Append, but returns ErrFull if the list is bounded and full.  If the list was made with NewRing, the head is removed instead. */
+func (l *List) TryAppend(v interface{}) (*Element, error) {
+	/* Make an element for the Value. */
+	e := &Element{value: v, l: l}
+	l.m.Lock()
+	defer l.unlock()
+	/* Checking under the same lock as the insert keeps concurrent
+	appends from overfilling the list. */
+	if l.full() {
+		if !l.ring {
+			return nil, ErrFull
+		}
+		l.evict()
+	}
+	/* Append the element to the tail. */
+	l.insertAfter(e, l.tail)
+	return e, nil
+}
+
+/* AppendWithInfo is like Append, but also returns whether the list was empty, as by IsEmpty, just before v was appended.  As both happen under one lock, this can be used to tell which of several appenders made the list non-empty.  If the list is bounded and full, AppendWithInfo returns nil and false. */
+func (l *List) AppendWithInfo(v interface{}) (e *Element, wasEmpty bool) {
+	e = &Element{value: v, l: l}
+	l.m.Lock()
+	defer l.unlock()
+	wasEmpty = l.head == nil
+	if l.full() {
+		if !l.ring {
+			return nil, false
+		}
+		l.evict()
+	}
+	l.insertAfter(e, l.tail)
+	return e, wasEmpty
+}
+
+/* PushRing appends v to the list and returns the generated Element.  If the list is bounded and full, whether or not it was made with NewRing, the head is removed first, and its value is returned with ok set to true.  The removal and the append happen under one lock. */
+func (l *List) PushRing(v interface{}) (e *Element, evicted interface{}, ok bool) {
+	e = &Element{value: v, l: l}
+	l.m.Lock()
+	defer l.unlock()
+	if l.full() {
+		evicted, ok = l.evict(), true
+	}
+	l.insertAfter(e, l.tail)
+	return e, evicted, ok
+}
+
+/* evict removes the head of the list, marked or not, and returns its value.  The list must be write-locked by the caller and not empty. */
+func (l *List) evict() interface{} {
+	e := l.head
+	l.unlink(e)
+	return e.Value()
+}
+
+/* AppendUnique appends v to the list unless the value of an element not marked for removal is already equal to v according to eq, or == if eq is nil.  It returns the new or existing element and whether it was newly appended.  The search and append happen under one lock, so concurrent calls can't both append the same value.  eq is called with the list write-locked, so it mustn't use the list.  If the list is bounded and full, AppendUnique returns nil and false. */
+func (l *List) AppendUnique(v interface{}, eq func(a, b interface{}) bool) (*Element, bool) {
+	if eq == nil {
+		eq = equal
+	}
+	l.m.Lock()
+	defer l.unlock()
+	/* Look for an existing one first. */
+	for e := live(l.head); e != nil; e = live(e.next) {
+		if eq(e.Value(), v) {
+			return e, false
+		}
+	}
+	if l.full() {
+		return nil, false
+	}
+	e := &Element{value: v, l: l}
+	l.insertAfter(e, l.tail)
+	return e, true
+}
+
+/* GetOrAppend returns the first element not marked for removal for which keyOf(value) == key, or if there is none, appends the value returned by mk and returns the new element.  The bool is true if an element was appended.  The search and append happen under one lock, so concurrent calls with the same key append at most once.  keyOf and mk are called with the list write-locked, so they mustn't use the list.  If the list is bounded and full and no element matches, GetOrAppend returns nil and false without calling mk. */
+func (l *List) GetOrAppend(key interface{}, keyOf func(v interface{}) interface{}, mk func() interface{}) (*Element, bool) {
+	l.m.Lock()
+	defer l.unlock()
+	for e := live(l.head); e != nil; e = live(e.next) {
+		if keyOf(e.Value()) == key {
+			return e, false
+		}
+	}
+	if l.full() {
+		return nil, false
+	}
+	e := &Element{value: mk(), l: l}
+	l.insertAfter(e, l.tail)
+	return e, true
+}
+
+/* InsertSorted inserts v just before the first element not marked for removal for which less(v, element's value) is true, or at the tail if there is none, and returns the new element.  If the list is sorted by less, it stays sorted.  The search and insert happen under one lock, so concurrent calls keep the order.  less is called with the list write-locked, so it mustn't use the list.  If the list is bounded and full, InsertSorted returns nil. */
+func (l *List) InsertSorted(v interface{}, less func(a, b interface{}) bool) *Element {
+	l.m.Lock()
+	defer l.unlock()
+	if l.full() {
+		return nil
+	}
+	/* Find the first element which goes after v. */
+	at := l.tail
+	for e := live(l.head); e != nil; e = live(e.next) {
+		if less(v, e.Value()) {
+			at = e.prev
+			break
+		}
+	}
+	n := &Element{value: v, l: l}
+	l.insertAfter(n, at)
+	return n
+}
+
+/* InsertAt inserts v so that it ends up at index i, counting only elements not marked for removal, and returns the new element.  An i of 0 prepends and an i of LiveLen() appends.  Other indices out of that range return ErrIndex, and inserting into a full bounded list returns ErrFull.  This runs in O(n) time. */
+func (l *List) InsertAt(i int, v interface{}) (*Element, error) {
+	l.m.Lock()
+	defer l.unlock()
+	if i < 0 {
+		return nil, ErrIndex
+	}
+	/* Find the element which will be just before the new one. */
+	var at *Element
+	if i > 0 {
+		if at = l.at(i - 1); at == nil {
+			return nil, ErrIndex
+		}
+	}
+	if l.full() {
+		return nil, ErrFull
+	}
+	e := &Element{value: v, l: l}
+	l.insertAfter(e, at)
+	return e, nil
+}
+
+/* PushBack is an alias for Append. */
+func (l *List) PushBack(v interface{}) *Element {
+	return l.Append(v)
+}
+
+/* Concat appends the values of the elements in other not marked for removal to l, in order, as new elements.  other isn't changed.  Concatenating a list with itself doubles it, and a nil other is a no-op.  If l is bounded, Concat stops when it's full. */
+func (l *List) Concat(other *List) {
+	if other == nil {
+		return
+	}
+	/* Snapshotting other first means we never hold both locks. */
+	l.AppendAll(other.ToSlice())
+}
+
+/* AppendAll appends each of vs to the list, in order, under a single lock, and returns the generated Elements.  If the list is bounded, AppendAll stops when it's full, so fewer Elements than values may be returned. */
+func (l *List) AppendAll(vs []interface{}) []*Element {
+	es := make([]*Element, 0, len(vs))
+	l.m.Lock()
+	defer l.unlock()
+	for _, v := range vs {
+		if l.full() {
+			break
+		}
+		e := &Element{value: v, l: l}
+		l.insertAfter(e, l.tail)
+		es = append(es, e)
+	}
+	return es
+}
+
+/* AppendValues is a variadic AppendAll which returns only the last Element it made, or nil if it made none, e.g. l.AppendValues(1, 2, 3). */
+func (l *List) AppendValues(vs ...interface{}) *Element {
+	es := l.AppendAll(vs)
+	if len(es) == 0 {
+		return nil
+	}
+	return es[len(es)-1]
+}
+
+/* PushFront prepends a value to the list and returns the generated Element in O(1) time.  If the list is bounded and full, PushFront returns nil. */
+func (l *List) PushFront(v interface{}) *Element {
+	e, _ := l.TryPushFront(v)
+	return e
+}
+
+/* TryPushFront is like PushFront, but returns ErrFull if the list is bounded and full. */
+func (l *List) TryPushFront(v interface{}) (*Element, error) {
+	/* Make an element for the Value. */
+	e := &Element{value: v, l: l}
+	l.m.Lock()
+	defer l.unlock()
+	if l.full() {
+		return nil, ErrFull
+	}
+	/* Put the element before the head. */
+	l.insertAfter(e, nil)
+	return e, nil
+}
+
+/* Version returns a number which is increased every time the list's structure changes, i.e. whenever elements are added, removed, or moved.  Marking elements and changing values don't count.  Comparing versions is a cheap way to tell if a list has been changed.  Iterators use it to stop if the list changes under them. */
+func (l *List) Version() uint64 {
+	return atomic.LoadUint64(&l.version)
+}
+
+/* touch increases the list's version.  The list must be write-locked by the caller. */
+func (l *List) touch() {
+	atomic.AddUint64(&l.version, 1)
+}
+
+/* ChangeKind is the kind of change described by a ChangeEvent. */
+type ChangeKind int
+
+/* Kinds of ChangeEvent. */
+const (
+	ChangeInsert  ChangeKind = iota /* An element was added */
+	ChangeRemove                    /* An element was removed */
+	ChangeMove                      /* An element was moved */
+	ChangeReorder                   /* Several elements were moved */
+)
+
+/* ChangeEvent describes a change to a list, as passed to the functions given to OnChange.  Value is the value of the element added, removed, or moved, or nil for ChangeReorder. */
+type ChangeEvent struct {
+	Kind  ChangeKind
+	Value interface{}
+}
+
+/* OnChange registers fn to be called after each change to the list's structure, i.e. each one which changes its Version.  Any number of functions may be registered; each is called for each change, in the order they were registered.  fn is called after the list is unlocked, so it may use the list, and calls for each change are made one at a time and in the order the changes happened.  This means fn may be called by a different goroutine from the one which made the change, possibly after the method which made it has returned.  Moving an element with InsertAfter-like methods, Swap, and the like gives a ChangeMove; Reverse, Rotate, Sort, and Splice give a single ChangeReorder; Clear gives a ChangeRemove for every element. */
+func (l *List) OnChange(fn func(ev ChangeEvent)) {
+	l.m.Lock()
+	defer l.unlock()
+	l.hm.Lock()
+	defer l.hm.Unlock()
+	l.hooks = append(l.hooks, fn)
+}
+
+/* emit queues an event for the list's hooks, if it has any.  The list must be write-locked by the caller. */
+func (l *List) emit(k ChangeKind, v interface{}) {
+	if len(l.hooks) == 0 {
+		return
+	}
+	l.hm.Lock()
+	defer l.hm.Unlock()
+	l.events = append(l.events, ChangeEvent{Kind: k, Value: v})
+}
+
+/* unlock write-unlocks the list and then passes any queued events to its hooks. */
+func (l *List) unlock() {
+	hooked := len(l.hooks) != 0
+	l.m.Unlock()
+	if hooked {
+		l.fire()
+	}
+}
+
+/* fire calls the list's hooks with queued events until there are none left, unless another goroutine is already doing it.  Events queued by the hooks themselves are fired by the same loop. */
+func (l *List) fire() {
+	l.hm.Lock()
+	if l.firing {
+		l.hm.Unlock()
+		return
+	}
+	l.firing = true
+	for len(l.events) != 0 {
+		evs, hooks := l.events, l.hooks
+		l.events = nil
+		l.hm.Unlock()
+		for _, ev := range evs {
+			for _, fn := range hooks {
+				fn(ev)
+			}
+		}
+		l.hm.Lock()
+	}
+	l.firing = false
+	l.hm.Unlock()
+}
+
+/* full returns true if the list is bounded and at capacity.  The list must be locked by the caller. */
+func (l *List) full() bool {
+	return l.max > 0 && l.size >= l.max
+}
+
+/* insertAfter links e into the list just after at, or at the front of the list if at is nil.  The list must be write-locked by the caller.  e mustn't already be in the list. */
+func (l *List) insertAfter(e, at *Element) {
+	l.link(e, at, ChangeInsert)
+}
+
+/* move moves e, which must be in the list, to just after at, or to the front of the list if at is nil.  The list must be write-locked by the caller. */
+func (l *List) move(e, at *Element) {
+	l.detach(e)
+	l.link(e, at, ChangeMove)
+}
+
+/* link does the work for insertAfter and move, queueing an event of kind k. */
+func (l *List) link(e, at *Element, k ChangeKind) {
+	/* Lock the element before, this element, and the element after. */
+	next := l.head
+	if at != nil {
+		at.m.Lock()
+		defer at.m.Unlock()
+		next = at.next
+	}
+	e.m.Lock()
+	defer e.m.Unlock()
+	if next != nil {
+		next.m.Lock()
+		defer next.m.Unlock()
+	}
+	/* Link in the element on both sides. */
+	e.prev = at
+	e.next = next
+	if at == nil {
+		l.head = e
+	} else {
+		at.next = e
+	}
+	if next == nil {
+		l.tail = e
+	} else {
+		next.prev = e
+	}
+	l.size++
+	l.touch()
+	l.emit(k, e.value)
+	/* Wake up anybody waiting for an element. */
+	l.signal()
+}
+
+/* Clear removes every element from the list.  The removed elements are detached as if Remove had been called on each, so elements still held by callers have nil Next() and Prev() and are safe to Remove again.  Detaching them makes this O(n), though under a single lock. */
+func (l *List) Clear() {
+	l.m.Lock()
+	defer l.unlock()
+	l.clear()
+}
+
+/* clear does the work for Clear.  The list must be write-locked by the caller. */
+func (l *List) clear() {
+	for e := l.head; e != nil; {
+		e.m.Lock()
+		next := e.next
+		e.removed = true
+		e.next = nil
+		e.prev = nil
+		l.emit(ChangeRemove, e.value)
+		e.m.Unlock()
+		e = next
+	}
+	l.head = nil
+	l.tail = nil
+	l.size = 0
+	atomic.StoreInt64(&l.marked, 0)
+	l.touch()
+	l.signal()
+}
+
+/* PopFront removes the first element in the list not marked for removal and returns its value.  If there is no such element, ok is false. */
+func (l *List) PopFront() (v interface{}, ok bool) {
+	l.m.Lock()
+	defer l.unlock()
+	e := live(l.head)
+	if e == nil {
+		return nil, false
+	}
+	l.unlink(e)
+	return e.Value(), true
+}
+
+/* PopBack removes the last element in the list not marked for removal and returns its value.  If there is no such element, ok is false. */
+func (l *List) PopBack() (v interface{}, ok bool) {
+	l.m.Lock()
+	defer l.unlock()
+	e := liveBack(l.tail)
+	if e == nil {
+		return nil, false
+	}
+	/* Same locking as Remove, via unlink. */
+	l.unlink(e)
+	return e.Value(), true
+}
+
+/* AppendWait is like TryAppend, but if the list is bounded and full it waits until an element is removed or ctx is done, rather than returning ErrFull.  In the latter case, ctx's error is returned.  A list made with NewRing is never full, so this doesn't wait. */
+func (l *List) AppendWait(ctx context.Context, v interface{}) error {
+	e := &Element{value: v, l: l}
+	l.m.Lock()
+	defer l.unlock()
+	for l.full() && !l.ring {
+		if err := l.wait(ctx); err != nil {
+			return err
+		}
+	}
+	if l.full() {
+		l.evict()
+	}
+	l.insertAfter(e, l.tail)
+	return nil
+}
+
+/* PopFrontWait is like PopFront, but if the list is empty it waits until an element is added or ctx is done.  In the latter case, ctx's error is returned. */
+func (l *List) PopFrontWait(ctx context.Context) (interface{}, error) {
+	l.m.Lock()
+	defer l.unlock()
+	for {
+		if e := live(l.head); e != nil {
+			l.unlink(e)
+			return e.Value(), nil
+		}
+		if err := l.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+/* WaitNonEmpty returns nil as soon as the list has an element not marked for removal, which may be immediately, or ctx's error if ctx is done first.  Another goroutine may take the element before the caller gets to it. */
+func (l *List) WaitNonEmpty(ctx context.Context) error {
+	l.m.Lock()
+	defer l.unlock()
+	for live(l.head) == nil {
+		if err := l.wait(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* Drain returns a channel to which the values of the elements in the list are sent, as by PopFront, such that they're removed from the list as they're sent.  If wait is false, the channel is closed once the list is empty.  If wait is true, Drain waits for more elements, as with PopFrontWait, until ctx is done.  Either way, the channel is closed when ctx is done.  A value popped but not yet received when ctx is done is put back at the front of the list, ignoring the list's bound if need be, so no values are lost. */
+func (l *List) Drain(ctx context.Context, wait bool) <-chan interface{} {
+	ch := make(chan interface{})
+	go func() {
+		defer close(ch)
+		for {
+			var (
+				v  interface{}
+				ok bool
+			)
+			if wait {
+				var err error
+				v, err = l.PopFrontWait(ctx)
+				ok = err == nil
+			} else {
+				v, ok = l.PopFront()
+			}
+			if !ok {
+				return
+			}
+			select {
+			case ch <- v:
+			case <-ctx.Done():
+				l.putBack(v)
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+/* putBack puts v back at the front of the list after it's been popped.  As it was only just in the list, the list's bound is ignored, lest v be lost. */
+func (l *List) putBack(v interface{}) {
+	e := &Element{value: v, l: l}
+	l.m.Lock()
+	defer l.unlock()
+	l.insertAfter(e, nil)
+}
+
+/* wait waits until the list's condition variable is signaled or ctx is done, and returns ctx's error.  The list must be write-locked by the caller.  As with sync.Cond.Wait, the list is unlocked while waiting. */
+func (l *List) wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	/* Wake up the waiter if ctx is done first.  Broadcasting under the
+	lock means we can't miss it between checking ctx and waiting. */
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.m.Lock()
+			l.cond().Broadcast()
+			l.unlock()
+		case <-stop:
+		}
+	}()
+	l.cond().Wait()
+	return ctx.Err()
+}
+
+/* signal wakes up anybody waiting on the list's condition variable.  The list must be write-locked by the caller. */
+func (l *List) signal() {
+	if l.c != nil {
+		l.c.Broadcast()
+	}
+}
+
+/* cond returns the list's condition variable, making it if need be.  The list must be write-locked by the caller. */
+func (l *List) cond() *sync.Cond {
+	if l.c == nil {
+		l.c = sync.NewCond(&l.m)
+	}
+	return l.c
+}
+
+/* RemoveIf removes every element not marked for removal whose value satisfies pred, in a single pass, and returns the number removed.  The list is write-locked while pred runs, so pred mustn't use the list. */
+func (l *List) RemoveIf(pred func(v interface{}) bool) int {
+	l.m.Lock()
+	defer l.unlock()
+	n := 0
+	for e := live(l.head); e != nil; {
+		/* Grab the next one before e's unlinked. */
+		next := live(e.next)
+		if pred(e.Value()) {
+			l.unlink(e)
+			n++
+		}
+		e = next
+	}
+	return n
+}
+
+/* RemoveElements removes each of es which is still in the list under a single lock, and returns the number removed.  Elements which have already been removed, are in another list, or are nil are skipped, as are repeats.  Removing many elements this way is quicker than calling Remove on each, and concurrent insertions see either none or all of the removals. */
+func (l *List) RemoveElements(es []*Element) int {
+	l.m.Lock()
+	defer l.unlock()
+	n := 0
+	for _, e := range es {
+		if e == nil || e.list() != l || e.removed {
+			continue
+		}
+		l.unlink(e)
+		n++
+	}
+	return n
+}
+
+/* RemoveAt removes the element at index i, counting only elements not marked for removal, and returns its value.  If i is out of range, ErrIndex is returned.  Unlike Get, negative indices are out of range.  This runs in O(n) time. */
+func (l *List) RemoveAt(i int) (interface{}, error) {
+	l.m.Lock()
+	defer l.unlock()
+	if i < 0 {
+		return nil, ErrIndex
+	}
+	e := l.at(i)
+	if e == nil {
+		return nil, ErrIndex
+	}
+	l.unlink(e)
+	return e.Value(), nil
+}
+
+/* DeleteValue removes the first element not marked for removal whose value is equal to v according to eq, or == if eq is nil, and returns whether it found one.  The search and removal happen under one lock.  eq is called with the list write-locked, so it mustn't use the list. */
+func (l *List) DeleteValue(v interface{}, eq func(a, b interface{}) bool) bool {
+	if eq == nil {
+		eq = equal
+	}
+	l.m.Lock()
+	defer l.unlock()
+	for e := live(l.head); e != nil; e = live(e.next) {
+		if eq(e.Value(), v) {
+			l.unlink(e)
+			return true
+		}
+	}
+	return false
+}
+
+/* Dedup removes every element not marked for removal whose value is equal to that of an earlier element, according to eq, and returns the number removed.  If eq is nil, values are compared with == using a map, in O(n) time, which panics if a value isn't comparable.  With an eq it takes O(n^2) time.  The list is write-locked while eq runs, so eq mustn't use the list. */
+func (l *List) Dedup(eq func(a, b interface{}) bool) int {
+	l.m.Lock()
+	defer l.unlock()
+	var (
+		n    int
+		seen = make(map[interface{}]bool)
+		kept []interface{}
+	)
+	for e := live(l.head); e != nil; {
+		/* Grab the next one before e's unlinked. */
+		next := live(e.next)
+		v := e.Value()
+		var dup bool
+		if eq == nil {
+			dup = seen[v]
+		} else {
+			/* v needn't be hashable, so don't go near seen. */
+			for _, k := range kept {
+				if dup = eq(k, v); dup {
+					break
+				}
+			}
+		}
+		if dup {
+			l.unlink(e)
+			n++
+		} else if eq == nil {
+			seen[v] = true
+		} else {
+			kept = append(kept, v)
+		}
+		e = next
+	}
+	return n
+}
+
+/* unlink removes e from the list.  The list must be write-locked by the caller. */
+func (l *List) unlink(e *Element) {
+	/* Lock the previous element, this element, and the next. */
+	defer lockElements(e.prev, e, e.next)()
+	l.unlinkLocked(e)
+}
+
+/* unlinkLocked is like unlink, but e and its neighbors must also be write-locked by the caller. */
+func (l *List) unlinkLocked(e *Element) {
+	l.detachLocked(e)
+	e.removed = true
+	l.emit(ChangeRemove, e.value)
+	/* A removed element is a detached node; it has no neighbors. */
+	e.next = nil
+	e.prev = nil
+	/* It no longer counts as marked. */
+	if e.remove {
+		atomic.AddInt64(&l.marked, -1)
+	}
+}
+
+/* detach takes e out of the list's links without marking it removed, so it may be linked back in elsewhere with insertAfter.  The list must be write-locked by the caller. */
+func (l *List) detach(e *Element) {
+	/* Lock the previous element, this element, and the next. */
+	defer lockElements(e.prev, e, e.next)()
+	l.detachLocked(e)
+}
+
+/* detachLocked is like detach, but e and its neighbors must also be write-locked by the caller. */
+func (l *List) detachLocked(e *Element) {
+	/* Decrease the element count, and wake up anybody waiting for
+	room. */
+	l.size--
+	l.touch()
+	l.signal()
+	/* If it's the only item, empty the list. */
+	if nil == e.prev && e.next == nil {
+		l.head = nil
+		l.tail = nil
+		return
+	}
+	/* If it's the head, the next element becomes the new head. */
+	if e.prev == nil {
+		l.head = e.next
+		e.next.prev = nil
+		return
+	}
+	/* If it's the tail, the previous element becomes the new tail. */
+	if e.next == nil {
+		l.tail = e.prev
+		e.prev.next = nil
+		return
+	}
+	/* If it's an internal element, unlink it from both sides. */
+	e.prev.next = e.next
+	e.next.prev = e.prev
+}
+
+/* Reverse reverses the order of the list in place in O(n) time.  Elements held by callers remain valid; only their directions change. */
+func (l *List) Reverse() {
+	l.m.Lock()
+	defer l.unlock()
+	/* Swap each element's links. */
+	for e := l.head; e != nil; {
+		e.m.Lock()
+		next := e.next
+		e.next, e.prev = e.prev, e.next
+		e.m.Unlock()
+		e = next
+	}
+	l.head, l.tail = l.tail, l.head
+	l.touch()
+	l.emit(ChangeReorder, nil)
+}
+
+/* Rotate moves the first n elements of the list to the back, or for negative n, the last -n elements to the front, in O(n) time.  n may be larger than the list, in which case it wraps around.  Elements marked for removal are counted.  Elements held by callers remain valid. */
+func (l *List) Rotate(n int) {
+	l.m.Lock()
+	defer l.unlock()
+	if l.size < 2 {
+		return
+	}
+	if n %= l.size; n < 0 {
+		n += l.size
+	}
+	if n == 0 {
+		return
+	}
+	/* Find the element which will be the new tail. */
+	t := l.head
+	for i := 1; i < n; i++ {
+		t = t.next
+	}
+	h := t.next
+	/* Join the ends and cut after the new tail. */
+	defer lockElements(l.head, l.tail, t, h)()
+	l.tail.next = l.head
+	l.head.prev = l.tail
+	t.next = nil
+	h.prev = nil
+	l.head, l.tail = h, t
+	l.touch()
+	l.emit(ChangeReorder, nil)
+}
+
+/* Sort sorts the list in place with a stable merge sort, using less to compare values.  The elements themselves are relinked, so elements held by callers remain valid.  The list and all of its elements are locked while sorting, so less mustn't use the list. */
+func (l *List) Sort(less func(a, b interface{}) bool) {
+	l.m.Lock()
+	defer l.unlock()
+	/* Lock every element, as all of their links may change. */
+	for e := l.head; e != nil; e = e.next {
+		e.m.Lock()
+	}
+	l.head = mergeSort(l.head, less)
+	/* Fix up the prev links and the tail. */
+	var prev *Element
+	for e := l.head; e != nil; e = e.next {
+		e.prev = prev
+		prev = e
+	}
+	l.tail = prev
+	l.touch()
+	l.emit(ChangeReorder, nil)
+	for e := l.head; e != nil; e = e.next {
+		e.m.Unlock()
+	}
+}
+
+/* mergeSort sorts the chain of elements starting at head by value using less and returns the new head.  Only the next links are followed and set.  The elements must be write-locked by the caller. */
+func mergeSort(head *Element, less func(a, b interface{}) bool) *Element {
+	if head == nil || head.next == nil {
+		return head
+	}
+	/* Split the chain in half and sort the halves. */
+	slow, fast := head, head.next
+	for fast != nil && fast.next != nil {
+		slow = slow.next
+		fast = fast.next.next
+	}
+	right := slow.next
+	slow.next = nil
+	left := mergeSort(head, less)
+	right = mergeSort(right, less)
+	/* Merge them, taking from the left on ties to keep it stable. */
+	var h Element
+	t := &h
+	for left != nil && right != nil {
+		if less(right.value, left.value) {
+			t.next = right
+			right = right.next
+		} else {
+			t.next = left
+			left = left.next
+		}
+		t = t.next
+	}
+	if left != nil {
+		t.next = left
+	} else {
+		t.next = right
+	}
+	return h.next
+}
+
+/* Splice moves the run of elements from from to to, inclusive, to just after after, or to the front of the list if after is nil, in O(1) time.  from and to must be in l, and to must be from or come after it; as checking this would take O(n) time, it's up to the caller.  after must not be in the run.  Nothing happens if any of the elements have been removed. */
+func (l *List) Splice(from, to, after *Element) {
+	l.m.Lock()
+	defer l.unlock()
+	/* Make sure the elements are in this list and not in the way. */
+	if from.list() != l || to.list() != l || from.removed || to.removed ||
+		after == from || after == to {
+		return
+	}
+	if after != nil && (after.list() != l || after.removed) {
+		return
+	}
+	/* Cut out the run. */
+	unlock := lockElements(from.prev, from, to, to.next)
+	if from.prev == nil {
+		l.head = to.next
+	} else {
+		from.prev.next = to.next
+	}
+	if to.next == nil {
+		l.tail = from.prev
+	} else {
+		to.next.prev = from.prev
+	}
+	unlock()
+	/* Link it back in after after. */
+	next := l.head
+	if after != nil {
+		next = after.next
+	}
+	unlock = lockElements(after, from, to, next)
+	defer unlock()
+	from.prev = after
+	to.next = next
+	if after == nil {
+		l.head = from
+	} else {
+		after.next = from
+	}
+	if next == nil {
+		l.tail = to
+	} else {
+		next.prev = to
+	}
+	l.touch()
+	l.emit(ChangeReorder, nil)
+}
+
+/* Swap exchanges the positions of a and b in the list by relinking them, so elements held by callers follow their values.  Nothing happens if a and b are the same or if either isn't in l. */
+func (l *List) Swap(a, b *Element) {
+	l.m.Lock()
+	defer l.unlock()
+	if a == b || a.list() != l || b.list() != l || a.removed || b.removed {
+		return
+	}
+	/* Below only works if a isn't just after b. */
+	if b == a.prev {
+		a, b = b, a
+	}
+	/* Put a where b is, then b where a was. */
+	ap := a.prev
+	l.move(a, b)
+	l.move(b, ap)
+}
+
+/* lockElements write-locks each distinct non-nil element in es and returns a function which unlocks them.  The lists the elements are in must be write-locked by the caller; as nothing else holds more than one element lock without them, the order doesn't matter. */
+func lockElements(es ...*Element) func() {
+	var locked []*Element
+outer:
+	for _, e := range es {
+		if e == nil {
+			continue
+		}
+		for _, o := range locked {
+			if o == e {
+				continue outer
+			}
+		}
+		e.m.Lock()
+		locked = append(locked, e)
+	}
+	return func() {
+		for _, e := range locked {
+			e.m.Unlock()
+		}
+	}
+}
+
+/* RemoveMarked sweeps through the list and calls Remove() on each element that is marked for removal.  Frequent additions to the list and scheduled removals may cause this to take a while.  It can be run asnychronously by wrapping it in a goroutine.  This runs in O(n) time, but not in a good way, and could probably use a re-write.  (hint, hint, people who found this on github).  */
+func (l *List) RemoveMarked() {
+	l.RemoveMarkedContext(context.Background())
+}
+
+/* Compact removes every element marked for removal in a single pass, under one lock, and returns the number removed.  Unlike RemoveMarked, it doesn't have to make repeated sweeps, but nothing else can use the list while it runs. */
+func (l *List) Compact() int {
+	l.m.Lock()
+	defer l.unlock()
+	n := 0
+	for e := l.head; e != nil; {
+		/* Grab the next one before e's unlinked. */
+		next := e.next
+		if e.ToRemove() {
+			l.unlink(e)
+			n++
+		}
+		e = next
+	}
+	return n
+}
+
+/* RemoveMarkedContext is like RemoveMarked, but checks ctx between elements and stops sweeping, returning ctx's error, if it's done.  The list is left consistent, if not completely swept. */
+func (l *List) RemoveMarkedContext(ctx context.Context) error {
+	/* Keep trying until we get a clean sweep */
+	for done := false; !done; {
+		done = true
+		l.m.RLock()
+		e := l.head
+		l.m.RUnlock()
+		/* Iterate through list, remove marked elements. */
+		for e != nil {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			/* Next() skips marked elements, so walk the raw links,
+			grabbing the next one before e is unlinked. */
+			e.m.RLock()
+			next, gone := e.next, e.removed
+			e.m.RUnlock()
+			/* Someone else removed e, so its links are gone.  Start
+			over. */
+			if gone {
+				done = false
+				break
+			}
+			if e.ToRemove() {
+				e.Remove()
+				done = false
+			}
+			e = next
+		}
+	}
+	return nil
+}
+
+/* ToSlice returns the values of the elements in the list which aren't marked for removal, in order.  The list is read-locked while the slice is built, so it's a point-in-time snapshot with respect to insertions and removals, but not to concurrent calls to RemoveMark.  An empty list yields an empty, non-nil slice. */
+func (l *List) ToSlice() []interface{} {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	vs := make([]interface{}, 0, l.size)
+	for e := live(l.head); e != nil; e = live(e.next) {
+		vs = append(vs, e.Value())
+	}
+	return vs
+}
+
+/* Clone returns a new list with new elements holding the values of the elements in l not marked for removal, in order.  The values themselves are copied as-is, so pointers, maps, slices and the like will be shared between the lists.  The new list has the same bound as l, if any, and is a ring if l is. */
+func (l *List) Clone() *List {
+	return l.CloneFunc(nil)
+}
+
+/* CloneFunc is like Clone, but the new elements hold the values returned by calling copyVal with each of l's values, e.g. to make deep copies.  A nil copyVal copies values as-is, as Clone does.  l is read-locked while copyVal runs, so copyVal mustn't use l. */
+func (l *List) CloneFunc(copyVal func(v interface{}) interface{}) *List {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	c := &List{max: l.max, ring: l.ring}
+	for e := live(l.head); e != nil; e = live(e.next) {
+		v := e.Value()
+		if copyVal != nil {
+			v = copyVal(v)
+		}
+		c.Append(v)
+	}
+	return c
+}
+
+/* Filter returns a new list with new elements holding the values of the elements in l not marked for removal which satisfy pred, in order.  l is read-locked while pred runs, so pred mustn't use l.  The new list is unbounded, even if l isn't. */
+func (l *List) Filter(pred func(v interface{}) bool) *List {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	f := New()
+	for e := live(l.head); e != nil; e = live(e.next) {
+		if v := e.Value(); pred(v) {
+			f.Append(v)
+		}
+	}
+	return f
+}
+
+/* FilterInPlace is the in-place counterpart of Filter: it removes every element not marked for removal whose value doesn't satisfy pred, in a single pass, and returns the number removed.  The elements which are kept stay valid.  The list is write-locked while pred runs, so pred mustn't use the list. */
+func (l *List) FilterInPlace(pred func(v interface{}) bool) int {
+	return l.RemoveIf(func(v interface{}) bool { return !pred(v) })
+}
+
+/* Partition returns two new, unbounded lists: one with the values of the elements of l not marked for removal which satisfy pred, and one with the rest, both in order.  l isn't changed.  l is read-locked while pred runs, so pred mustn't use l. */
+func (l *List) Partition(pred func(v interface{}) bool) (matched, rest *List) {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	matched, rest = New(), New()
+	for e := live(l.head); e != nil; e = live(e.next) {
+		if v := e.Value(); pred(v) {
+			matched.Append(v)
+		} else {
+			rest.Append(v)
+		}
+	}
+	return matched, rest
+}
+
+/* Map returns a new list holding the result of calling fn on the value of each element in l not marked for removal, in order.  l is read-locked while fn runs, so fn mustn't use l.  The new list is unbounded, even if l isn't. */
+func (l *List) Map(fn func(v interface{}) interface{}) *List {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	m := New()
+	for e := live(l.head); e != nil; e = live(e.next) {
+		m.Append(fn(e.Value()))
+	}
+	return m
+}
+
+/* Reduce calls fn with an accumulator and the value of each element in l not marked for removal, from head to tail, and returns the final accumulator.  The accumulator starts as initial and is replaced by fn's return value after each call.  l is read-locked while fn runs, so fn mustn't use l. */
+func (l *List) Reduce(initial interface{}, fn func(acc, v interface{}) interface{}) interface{} {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	acc := initial
+	for e := live(l.head); e != nil; e = live(e.next) {
+		acc = fn(acc, e.Value())
+	}
+	return acc
+}
+
+/* Min returns the smallest value of the elements in l not marked for removal, according to less, and true, or false if there are no such elements.  If several values are smallest, the first is returned.  l is read-locked while less runs, so less mustn't use l. */
+func (l *List) Min(less func(a, b interface{}) bool) (interface{}, bool) {
+	return l.extreme(less)
+}
+
+/* Max is like Min, but returns the largest value. */
+func (l *List) Max(less func(a, b interface{}) bool) (interface{}, bool) {
+	return l.extreme(func(a, b interface{}) bool { return less(b, a) })
+}
+
+/* extreme returns the first value v for which first(w, v) is false for every other value w. */
+func (l *List) extreme(first func(a, b interface{}) bool) (interface{}, bool) {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	e := live(l.head)
+	if e == nil {
+		return nil, false
+	}
+	m := e.Value()
+	for e = live(e.next); e != nil; e = live(e.next) {
+		if v := e.Value(); first(v, m) {
+			m = v
+		}
+	}
+	return m, true
+}
+
+/* Count returns the number of elements in l not marked for removal whose values satisfy pred, or all of them if pred is nil.  l is read-locked while pred runs, so pred mustn't use l. */
+func (l *List) Count(pred func(v interface{}) bool) int {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	n := 0
+	for e := live(l.head); e != nil; e = live(e.next) {
+		if pred == nil || pred(e.Value()) {
+			n++
+		}
+	}
+	return n
+}
+
+/* ToMap returns a map from keyOf(value) to value for the value of each element in l not marked for removal.  If several values have the same key, the one nearest the tail wins.  l is read-locked while keyOf runs, so keyOf mustn't use l.  Like any map key, keyOf's results must be comparable. */
+func (l *List) ToMap(keyOf func(v interface{}) interface{}) map[interface{}]interface{} {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	m := make(map[interface{}]interface{}, l.size)
+	for e := live(l.head); e != nil; e = live(e.next) {
+		v := e.Value()
+		m[keyOf(v)] = v
+	}
+	return m
+}
+
+/* RLockAll read-locks the list and returns a function which unlocks it, e.g. defer l.RLockAll()().  While it's held, nothing can be added to, removed from, or moved within the list, so traversal with Element's Next and Prev sees a consistent list.  Values may still change and elements may still be marked for removal.  Element methods which don't modify the list are safe to call while it's held, but List methods lock the list themselves, and recursively read-locking can deadlock if a writer is waiting, so get the element at which to start (e.g. with Head) before calling RLockAll. */
+func (l *List) RLockAll() func() {
+	l.m.RLock()
+	return l.m.RUnlock
+}
+
+/* ReplaceAll sets the value of every element not marked for removal whose value is equal to old according to eq, or == if eq is nil, to new, and returns how many it set.  Each element is write-locked while it's compared and set, so eq mustn't use the list or its elements. */
+func (l *List) ReplaceAll(old, new interface{}, eq func(a, b interface{}) bool) int {
+	if eq == nil {
+		eq = equal
+	}
+	l.m.RLock()
+	defer l.m.RUnlock()
+	n := 0
+	for e := live(l.head); e != nil; e = live(e.next) {
+		e.m.Lock()
+		if eq(e.value, old) {
+			e.value = new
+			n++
+		}
+		e.m.Unlock()
+	}
+	return n
+}
+
+/* Take returns a new, unbounded list holding the values of the first n elements of l not marked for removal.  n is clamped to the range [0, LiveLen()].  l isn't changed. */
+func (l *List) Take(n int) *List {
+	/* A negative hi would mean no limit. */
+	if n < 0 {
+		n = 0
+	}
+	return l.window(0, n)
+}
+
+/* Drop is like Take, but returns everything after the first n values instead. */
+func (l *List) Drop(n int) *List {
+	return l.window(n, -1)
+}
+
+/* FirstN returns the values of the first n elements of l not marked for removal, in order, or all of them if there are fewer than n.  A negative n is treated as 0.  As with ToSlice, the result is never nil. */
+func (l *List) FirstN(n int) []interface{} {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	vs := make([]interface{}, 0, clamp(n, l.size))
+	for e := live(l.head); e != nil && len(vs) < n; e = live(e.next) {
+		vs = append(vs, e.Value())
+	}
+	return vs
+}
+
+/* LastN is like FirstN, but returns the values of the last n elements.  They're still in list order. */
+func (l *List) LastN(n int) []interface{} {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	vs := make([]interface{}, 0, clamp(n, l.size))
+	for e := liveBack(l.tail); e != nil && len(vs) < n; e = liveBack(e.prev) {
+		vs = append(vs, e.Value())
+	}
+	/* We walked backwards, so put them back in order. */
+	for i, j := 0, len(vs)-1; i < j; i, j = i+1, j-1 {
+		vs[i], vs[j] = vs[j], vs[i]
+	}
+	return vs
+}
+
+/* clamp returns n limited to the range [0, max]. */
+func clamp(n, max int) int {
+	if n < 0 {
+		return 0
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
+
+/* Chunk splits the values of the elements of l not marked for removal into new, unbounded lists of size values each, in order; the last may be shorter.  If size is 0 or less, all of the values go into one list.  An empty l yields no lists.  l isn't changed. */
+func (l *List) Chunk(size int) []*List {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	var (
+		cs []*List
+		c  *List
+	)
+	for e := live(l.head); e != nil; e = live(e.next) {
+		/* Start a new chunk when the last one's full. */
+		if c == nil || (size > 0 && c.size == size) {
+			c = New()
+			cs = append(cs, c)
+		}
+		c.Append(e.Value())
+	}
+	return cs
+}
+
+/* window returns a new list holding the values of the unmarked elements from index lo up to but not including index hi, or to the end if hi is negative. */
+func (l *List) window(lo, hi int) *List {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	w := New()
+	i := 0
+	for e := live(l.head); e != nil && (hi < 0 || i < hi); e = live(e.next) {
+		if i >= lo {
+			w.Append(e.Value())
+		}
+		i++
+	}
+	return w
+}
+
+/* Range calls fn for each element in the list not marked for removal, from head to tail, until fn returns false.  The list isn't locked while fn runs, only while finding the next element, so fn may safely call RemoveMark, Remove, or any other method on the list or its elements.  If fn removes both the element it was given and the one after it, though, there's no telling where in the list they were, so the traversal starts again from the head; elements still in the list may then be visited again. */
+func (l *List) Range(fn func(e *Element) bool) {
+	l.WalkFrom(nil, fn)
+}
+
+/* WalkFrom is like Range, but starts at start, or the first element after it if it's marked for removal, or at the head if start is nil.  This allows resuming an earlier traversal.  If start has been removed or isn't in l, WalkFrom returns without calling fn. */
+func (l *List) WalkFrom(start *Element, fn func(e *Element) bool) {
+	e := start
+	if e == nil {
+		e = l.Head()
+	} else {
+		l.m.RLock()
+		if start.list() == l && !start.removed {
+			e = live(start)
+		} else {
+			e = nil
+		}
+		l.m.RUnlock()
+	}
+	for e != nil {
+		next := e.Next()
+		if !fn(e) {
+			return
+		}
+		e = step(l, e, next, false)
+	}
+}
+
+/* walkBack is like Range, but goes from tail to head, starting again from the tail if need be. */
+func (l *List) walkBack(fn func(e *Element) bool) {
+	for e := l.Tail(); e != nil; {
+		prev := e.Prev()
+		if !fn(e) {
+			return
+		}
+		e = step(l, e, prev, true)
+	}
+}
+
+/* RangeMutable is like Range, but fn also says whether to remove the element it was given, which is done before moving on to the next element, and whether to stop.  The traversal carries on correctly past removed elements. */
+func (l *List) RangeMutable(fn func(e *Element) (remove, stop bool)) {
+	for e := l.Head(); e != nil; {
+		next := e.Next()
+		remove, stop := fn(e)
+		if remove {
+			e.Remove()
+		}
+		if stop {
+			return
+		}
+		e = step(l, e, next, false)
+	}
+}
+
+/* ForEach calls fn with the value of each element in the list not marked for removal, from head to tail, and returns the first error fn returns, if any.  Traversal stops at the first error.  As with Range, the list isn't locked while fn runs, so fn may modify the list. */
+func (l *List) ForEach(fn func(v interface{}) error) error {
+	var err error
+	l.Range(func(e *Element) bool {
+		err = fn(e.Value())
+		return err == nil
+	})
+	return err
+}
+
+/* ForEachReverse is like ForEach, but goes from tail to head.  If fn removes both the element whose value it was given and the one before it, traversal starts again from the tail. */
+func (l *List) ForEachReverse(fn func(v interface{}) error) error {
+	var err error
+	l.walkBack(func(e *Element) bool {
+		err = fn(e.Value())
+		return err == nil
+	})
+	return err
+}
+
+/* Find returns the first element in the list not marked for removal whose value satisfies pred, or nil if there is none.  As with Range, the list isn't locked while pred runs. */
+func (l *List) Find(pred func(v interface{}) bool) *Element {
+	var found *Element
+	l.Range(func(e *Element) bool {
+		if pred(e.Value()) {
+			found = e
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+/* FindLast is like Find, but returns the last matching element, searching backwards from the tail. */
+func (l *List) FindLast(pred func(v interface{}) bool) *Element {
+	var found *Element
+	l.walkBack(func(e *Element) bool {
+		if pred(e.Value()) {
+			found = e
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+/* Contains returns true if the value of any element in the list not marked for removal is equal to v, using ==.  Like ==, it will panic if v and a value in the list have the same non-comparable type (e.g. a slice or map). */
+func (l *List) Contains(v interface{}) bool {
+	return nil != l.Find(func(ev interface{}) bool { return ev == v })
+}
+
+/* Get returns the element at index i, counting only elements not marked for removal, or nil if i is out of range.  Negative indices count back from the tail, so -1 is the last element.  This runs in O(n) time. */
+func (l *List) Get(i int) *Element {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	return l.at(i)
+}
+
+/* at does the work for Get.  The list must be locked by the caller. */
+func (l *List) at(i int) *Element {
+	/* Walk backwards for negative indices. */
+	if i < 0 {
+		e := liveBack(l.tail)
+		for ; e != nil && i < -1; i++ {
+			e = liveBack(e.prev)
+		}
+		return e
+	}
+	e := live(l.head)
+	for ; e != nil && i > 0; i-- {
+		e = live(e.next)
+	}
+	return e
 }
 
-/* Len returns the length of l in O(1) time. */
-func (l *List) Len() int {
+/* IndexOf returns the index of the first element not marked for removal whose value is equal to v using ==, or -1 if there is none.  Indices line up with those of ToSlice and Get.  As with Contains, non-comparable values may cause a panic. */
+func (l *List) IndexOf(v interface{}) int {
 	l.m.RLock()
 	defer l.m.RUnlock()
-	return l.size
+	i := 0
+	for e := live(l.head); e != nil; e = live(e.next) {
+		if e.Value() == v {
+			return i
+		}
+		i++
+	}
+	return -1
 }
 
-/* Make a new list */
-func New() *List {
-	l := &List{}
-	return l
+/* Equal returns true if l and other have the same number of elements not marked for removal and eq returns true for each pair of their values, in order.  If eq is nil, values are compared with ==.  Both lists are read-locked during the comparison, so eq mustn't use them. */
+func (l *List) Equal(other *List, eq func(a, b interface{}) bool) bool {
+	if other == nil {
+		return false
+	}
+	if eq == nil {
+		eq = equal
+	}
+	defer rlockLists(l, other)()
+	a, b := live(l.head), live(other.head)
+	for ; a != nil && b != nil; a, b = live(a.next), live(b.next) {
+		if !eq(a.Value(), b.Value()) {
+			return false
+		}
+	}
+	/* Make sure we got to the end of both. */
+	return a == nil && b == nil
 }
 
-/* Head returns the first element of the list. */
-func (l *List) Head() *Element {
-	l.m.RLock()
-	defer l.m.RUnlock()
-        /* Return nil if we have no head */
-        if l.head == nil {
-                return nil
-        }
-        /* Keep trying until we get somewhere */
-	if l.head.ToRemove() {
-		return l.head.Next()
+/* Merge returns a new, unbounded list holding the values of the elements in a and b not marked for removal, merged in O(n+m) time.  If a and b are both sorted by less, so is the new list; on ties, values from a come first.  a and b are read-locked while merging, so less mustn't use them. */
+func Merge(a, b *List, less func(x, y interface{}) bool) *List {
+	defer rlockLists(a, b)()
+	m := New()
+	x, y := live(a.head), live(b.head)
+	for x != nil || y != nil {
+		/* Take from b only if it's strictly less. */
+		if x == nil || (y != nil && less(y.Value(), x.Value())) {
+			m.Append(y.Value())
+			y = live(y.next)
+		} else {
+			m.Append(x.Value())
+			x = live(x.next)
+		}
 	}
-	return l.head
+	return m
 }
 
-/* Append a value to the list and return the generated Element in O(1) time. */
-func (l *List) Append(v interface{}) *Element {
-	/* Make an element for the Value. */
-	e := &Element{value: v, l: l}
-	/* Make sure we have a head and tail. */
-	l.m.Lock()
-	defer l.m.Unlock()
-	/* Count */
-	defer func() { l.size++ }()
-	if l.head == nil {
-		l.head = e
-		l.tail = e
-		return e
+/* equal compares a and b with ==, for when the user doesn't supply a comparison function. */
+func equal(a, b interface{}) bool {
+	return a == b
+}
+
+/* lockLists write-locks a and b, in address order, and returns a function which unlocks them and then calls their hooks, as unlock does.  a and b may be the same list. */
+func lockLists(a, b *List) func() {
+	first, second := orderLists(a, b)
+	first.m.Lock()
+	if second == first {
+		return first.unlock
+	}
+	second.m.Lock()
+	return func() {
+		/* Don't call either's hooks until both are unlocked. */
+		fh, sh := len(first.hooks) != 0, len(second.hooks) != 0
+		second.m.Unlock()
+		first.m.Unlock()
+		if fh {
+			first.fire()
+		}
+		if sh {
+			second.fire()
+		}
+	}
+}
+
+/* rlockLists read-locks a and b, in address order, and returns a function which unlocks them.  a and b may be the same list. */
+func rlockLists(a, b *List) func() {
+	first, second := orderLists(a, b)
+	first.m.RLock()
+	if second == first {
+		return first.m.RUnlock
+	}
+	second.m.RLock()
+	return func() {
+		second.m.RUnlock()
+		first.m.RUnlock()
+	}
+}
+
+/* orderLists returns a and b in the order in which they should be locked, so that everything that locks two lists locks them in the same order and can't deadlock. */
+func orderLists(a, b *List) (*List, *List) {
+	if uintptr(unsafe.Pointer(b)) < uintptr(unsafe.Pointer(a)) {
+		return b, a
+	}
+	return a, b
+}
+
+/* live returns e or, if e is marked for removal, the first element after it which isn't.  The list must be locked by the caller, which keeps the links from changing. */
+func live(e *Element) *Element {
+	for e != nil && e.ToRemove() {
+		e = e.next
 	}
-	/* Append the element to the tail. */
-	l.tail.m.Lock()
-	defer l.tail.m.Unlock()
-	l.tail.next = e
-	/* Note the previous element. */
-	e.prev = l.tail
-	/* The element is the new tail */
-	l.tail = e
 	return e
 }
 
-/* PushBack is an alias for Append. */
-func (l *List) PushBack(v interface{}) *Element {
-	return l.Append(v)
+/* liveBack is like live, but searches towards the head of the list. */
+func liveBack(e *Element) *Element {
+	for e != nil && e.ToRemove() {
+		e = e.prev
+	}
+	return e
 }
 
-/* RemoveMarked sweeps through the list and calls Remove() on each element that is marked for removal.  Frequent additions to the list and scheduled removals may cause this to take a while.  It can be run asnychronously by wrapping it in a goroutine.  This runs in O(n) time, but not in a good way, and could probably use a re-write.  (hint, hint, people who found this on github).  */
-func (l *List) RemoveMarked() {
-	/* Keep trying until we get a clean sweep */
-	for done := true; !done; done = true {
-		e := l.head
-		/* Iterate through list, remove marked elements. */
-		for e != nil {
-			if e.ToRemove() {
-				e.Remove()
-				done = false
-			}
-			e = e.Next()
+/* step returns the element to visit after e during an unlocked traversal of l, given next, the element which followed e when e was visited.  If e was removed in the meantime, it no longer has a next element, so the traversal carries on from next instead.  If next has been removed as well, there's nothing left to go on, so the traversal starts again from l's head, or step returns nil if l is nil.  If back is true, the traversal is towards the head, and "next", "followed", and "head" mean previous, preceded, and tail. */
+func step(l *List, e, next *Element, back bool) *Element {
+	/* A removed element's links are cleared along with setting removed, so
+	checking afterwards covers a removal during the call to Next. */
+	if n := e.neighbor(back); n != nil || !e.isRemoved() {
+		return n
+	}
+	for next != nil {
+		next.m.RLock()
+		removed, marked, n := next.removed, next.remove, next.next
+		if back {
+			n = next.prev
+		}
+		next.m.RUnlock()
+		if removed {
+			return restart(l, back)
+		}
+		if !marked {
+			return next
+		}
+		next = n
+	}
+	return nil
+}
+
+/* restart returns the element at which step starts a traversal of l again, or nil if l is nil. */
+func restart(l *List, back bool) *Element {
+	switch {
+	case l == nil:
+		return nil
+	case back:
+		return l.Tail()
+	default:
+		return l.Head()
+	}
+}
+
+/* MarshalJSON encodes the values of the elements in the list not marked for removal as a JSON array. */
+func (l *List) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.ToSlice())
+}
+
+/* UnmarshalJSON replaces the contents of the list with the values in a JSON array.  As the values are decoded into interface{}s, they come back as the types encoding/json picks (e.g. float64 for numbers), not necessarily the types which were encoded. */
+func (l *List) UnmarshalJSON(b []byte) error {
+	var vs []interface{}
+	if err := json.Unmarshal(b, &vs); err != nil {
+		return err
+	}
+	return l.replace(vs)
+}
+
+/* GobEncode encodes the values of the elements in the list not marked for removal with encoding/gob.  As with any interface{} sent with gob, the concrete types of the values need to have been registered with gob.Register. */
+func (l *List) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(l.ToSlice()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+/* GobDecode replaces the contents of the list with values encoded by GobEncode. */
+func (l *List) GobDecode(b []byte) error {
+	var vs []interface{}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&vs); err != nil {
+		return err
+	}
+	return l.replace(vs)
+}
+
+/* replace replaces the contents of the list with new elements holding vs.  If the list is bounded and vs doesn't fit, the list is filled and ErrFull is returned. */
+func (l *List) replace(vs []interface{}) error {
+	l.m.Lock()
+	defer l.unlock()
+	l.clear()
+	for _, v := range vs {
+		if l.full() {
+			return ErrFull
+		}
+		l.insertAfter(&Element{value: v, l: l}, l.tail)
+	}
+	return nil
+}
+
+/* Validate checks that the list's links are consistent and returns an error describing the first problem found, or nil if there are none.  A correctly-used list should always validate, even while other goroutines mark its elements; this is meant for tests.  The count of marked elements isn't checked, as it can't be compared with the marks themselves while marking goes on. */
+func (l *List) Validate() error {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	return l.checkInvariants()
+}
+
+/* checkInvariants does the work for Validate.  The list must be locked by the caller. */
+func (l *List) checkInvariants() error {
+	if (l.head == nil) != (l.tail == nil) {
+		return fmt.Errorf("head is %p but tail is %p", l.head, l.tail)
+	}
+	if l.head != nil && l.head.prev != nil {
+		return fmt.Errorf("head has a previous element")
+	}
+	if l.tail != nil && l.tail.next != nil {
+		return fmt.Errorf("tail has a next element")
+	}
+	/* Walk forwards, giving up if it's longer than it should be, which
+	also catches loops. */
+	var (
+		n    int
+		last *Element
+	)
+	for e := l.head; e != nil; e = e.next {
+		if n++; n > l.size {
+			return fmt.Errorf("more than %v elements going forwards", l.size)
+		}
+		/* The links can't change with the list locked, but these can
+		only be read safely with e locked. */
+		e.m.RLock()
+		el, removed := e.l, e.removed
+		e.m.RUnlock()
+		if el != l {
+			return fmt.Errorf("element %v is in list %p, not %p", n-1, el, l)
+		}
+		if removed {
+			return fmt.Errorf("element %v is marked removed", n-1)
+		}
+		if e.prev != last {
+			return fmt.Errorf("element %v's previous element is wrong", n-1)
 		}
+		last = e
 	}
+	if last != l.tail {
+		return fmt.Errorf("walking forwards doesn't end at the tail")
+	}
+	if n != l.size {
+		return fmt.Errorf("%v elements going forwards, size is %v", n, l.size)
+	}
+	n = 0
+	for e := l.tail; e != nil; e = e.prev {
+		if n++; n > l.size {
+			return fmt.Errorf("more than %v elements going backwards", l.size)
+		}
+	}
+	if n != l.size {
+		return fmt.Errorf("%v elements going backwards, size is %v", n, l.size)
+	}
+	return nil
+}
+
+/* String returns the values of the elements in the list not marked for removal, formatted like a slice, e.g. [v0 v1 v2].  There's no limit on its length, so large lists make for large strings. */
+func (l *List) String() string {
+	return fmt.Sprint(l.ToSlice())
 }
 
 /* DebugPrint prints every element in the list to stdout.  This is meant for debugging purposes.  Production code should probably implement this better.  If w is not nil, the list will be output to w instead of stdout.  This is meant for easy diffing of two Lists. */
@@ -120,24 +1713,173 @@ func (e *Element) Value() interface{} {
 	return e.value
 }
 
-/* Next returns a pointer to the next Element in the list. */
-func (e *Element) Next() *Element {
+/* List returns the list e is in, or nil if e has been removed. */
+func (e *Element) List() *List {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	if e.removed {
+		return nil
+	}
+	return e.l
+}
+
+/* Same returns true if e and other are the same element, regardless of whether either is marked or removed.  Values aren't compared.  Either may be nil; two nils are the same. */
+func (e *Element) Same(other *Element) bool {
+	return e == other
+}
+
+/* Index returns e's index in its list, counting only elements not marked for removal, or -1 if e has been removed or is itself marked.  This runs in O(n) time. */
+func (e *Element) Index() int {
+	l := e.rlockList()
+	defer l.m.RUnlock()
+	i := 0
+	for o := live(l.head); o != nil; o = live(o.next) {
+		if o == e {
+			return i
+		}
+		i++
+	}
+	return -1
+}
+
+/* String returns an element's Value, formatted with fmt.Sprint. */
+func (e *Element) String() string {
+	return fmt.Sprint(e.Value())
+}
+
+/* SetValue sets an element's Value */
+func (e *Element) SetValue(v interface{}) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.value = v
+}
+
+/* SwapValue sets an element's Value and returns the old Value. */
+func (e *Element) SwapValue(v interface{}) interface{} {
+	e.m.Lock()
+	defer e.m.Unlock()
+	old := e.value
+	e.value = v
+	return old
+}
+
+/* SetValueIf sets e's value to v if its current value is equal to expected according to eq, or == if eq is nil, and returns whether it did.  The comparison and the set are atomic, making this a compare-and-swap which can be retried on failure.  eq is called with e locked, so it mustn't use e. */
+func (e *Element) SetValueIf(expected, v interface{}, eq func(a, b interface{}) bool) bool {
+	if eq == nil {
+		eq = equal
+	}
+	e.m.Lock()
+	defer e.m.Unlock()
+	if !eq(e.value, expected) {
+		return false
+	}
+	e.value = v
+	return true
+}
+
+/* SwapValues exchanges the values of a and b, leaving the elements where they are.  a and b may be in different lists. */
+func SwapValues(a, b *Element) {
+	if a == b {
+		return
+	}
+	/* Locking two elements is only safe with their lists locked, as
+	insertions and removals lock neighboring elements in list order.
+	As with MoveToList, retry if either's moved in the meantime. */
+	for {
+		la, lb := a.list(), b.list()
+		unlock := lockLists(la, lb)
+		if a.list() == la && b.list() == lb {
+			defer unlock()
+			break
+		}
+		unlock()
+	}
+	defer lockElements(a, b)()
+	a.value, b.value = b.value, a.value
+}
+
+/* WithReadLock calls fn with e's value and whether e is marked for removal, both read under one hold of e's read lock, so they're consistent with each other.  The lock is held while fn runs, so fn mustn't call e's methods (including Value and ToRemove, which is why their results are passed in) or anything which might lock e, such as removing or inserting next to it; doing so may deadlock. */
+func (e *Element) WithReadLock(fn func(v interface{}, marked bool)) {
 	e.m.RLock()
 	defer e.m.RUnlock()
+	fn(e.value, e.remove)
+}
+
+/* WithLock is like WithReadLock, but holds e's write lock and sets e's value to whatever fn returns.  This allows read-modify-write updates which can't race with other writers.  The same deadlock caveats apply. */
+func (e *Element) WithLock(fn func(v interface{}, marked bool) interface{}) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.value = fn(e.value, e.remove)
+}
+
+/* Next returns a pointer to the next Element in the list, skipping elements marked for removal.  Only one element is locked at a time, so walking past a long run of marked elements doesn't pile up read locks. */
+func (e *Element) Next() *Element {
+	e.m.RLock()
 	next := e.next
-	for next != nil && next.ToRemove() {
+	e.m.RUnlock()
+	for next != nil {
 		next.m.RLock()
-		defer next.m.RUnlock()
-		next = next.next
+		/* Check the mark under the same lock as the link. */
+		if !next.remove {
+			next.m.RUnlock()
+			return next
+		}
+		n := next.next
+		next.m.RUnlock()
+		next = n
+	}
+	return nil
+}
+
+/* Prev returns a pointer to the previous Element in the list, skipping elements marked for removal.  As with Next(), only one element is locked at a time; holding e's lock while locking its predecessor would also be out of order with Remove(). */
+func (e *Element) Prev() *Element {
+	e.m.RLock()
+	prev := e.prev
+	e.m.RUnlock()
+	for prev != nil {
+		prev.m.RLock()
+		/* Check the mark under the same lock as the link. */
+		if !prev.remove {
+			prev.m.RUnlock()
+			return prev
+		}
+		p := prev.prev
+		prev.m.RUnlock()
+		prev = p
+	}
+	return nil
+}
+
+/* Advance returns the element n steps after e, or for negative n, -n steps before it, skipping elements marked for removal as Next and Prev do.  If that runs off either end of the list, Advance returns nil.  An n of 0 returns e.  A removed element has no neighbors, so if e has been removed, Advance returns nil for any other n, as it does if an element it's stepping through is removed concurrently.  This runs in O(n) time. */
+func (e *Element) Advance(n int) *Element {
+	back := n < 0
+	if back {
+		n = -n
+	}
+	for ; n > 0 && e != nil; n-- {
+		e = e.neighbor(back)
+	}
+	return e
+}
+
+/* neighbor returns e.Next(), or e.Prev() if back is true. */
+func (e *Element) neighbor(back bool) *Element {
+	if back {
+		return e.Prev()
 	}
-	return next
+	return e.Next()
 }
 
 /* RemoveMark marks an element for removal.  The element will not actually be removed, but it'll be transparently ignored by Next().  This saves a potentially costly exclusive lock on the list and up to three elements at a cost of more expensive traversal (which uses shared locks).  List's RemoveMarked function will delete all such marked elements. */
 func (e *Element) RemoveMark() {
 	e.m.Lock()
 	defer e.m.Unlock()
+	/* Only count the first mark, and only if it's still in the list. */
+	if e.remove || e.removed {
+		return
+	}
 	e.remove = true
+	atomic.AddInt64(&e.l.marked, 1)
 }
 
 /* ToRemove indicates whether an element is marked for removal. */
@@ -147,48 +1889,264 @@ func (e *Element) ToRemove() bool {
 	return e.remove
 }
 
-/* Remove an element. */
+/* isRemoved indicates whether e has been removed from its list. */
+func (e *Element) isRemoved() bool {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.removed
+}
+
+/* list returns the list e is or was in.  As MoveToList can change it, it's read under e's lock. */
+func (e *Element) list() *List {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.l
+}
+
+/* lockList write-locks the list e is in and returns it.  If MoveToList moves e while waiting for the lock, the lock is released and e's new list is tried. */
+func (e *Element) lockList() *List {
+	for {
+		l := e.list()
+		l.m.Lock()
+		if e.list() == l {
+			return l
+		}
+		l.unlock()
+	}
+}
+
+/* rlockList is like lockList, but read-locks the list. */
+func (e *Element) rlockList() *List {
+	for {
+		l := e.list()
+		l.m.RLock()
+		if e.list() == l {
+			return l
+		}
+		l.m.RUnlock()
+	}
+}
+
+/* MoveToList moves e from its list to the back of dst, keeping its value, mark, and identity, in O(1) time.  Both lists are locked, in address order, while e is moved.  If dst is e's list, this is the same as MoveToBack.  Nothing happens if e has been removed or dst is bounded and full. */
+func (e *Element) MoveToList(dst *List) {
+	var src *List
+	for {
+		src = e.list()
+		unlock := lockLists(src, dst)
+		if e.list() == src {
+			defer unlock()
+			break
+		}
+		unlock()
+	}
+	if src == dst {
+		if !e.removed && e != src.tail {
+			src.move(e, src.tail)
+		}
+		return
+	}
+	if e.removed || dst.full() {
+		return
+	}
+	src.detach(e)
+	/* detach leaves e's list alone, so tell src's hooks it's gone. */
+	src.emit(ChangeRemove, e.Value())
+	e.m.Lock()
+	e.l = dst
+	if e.remove {
+		atomic.AddInt64(&src.marked, -1)
+		atomic.AddInt64(&dst.marked, 1)
+	}
+	e.m.Unlock()
+	dst.insertAfter(e, dst.tail)
+}
+
+/* Remove an element.  Afterwards e is a detached node: its Next and Prev return nil. */
 func (e *Element) Remove() {
-	/* Don't double-remove. */
+	/* Lock the list in case it's the head or tail. */
+	l := e.lockList()
+	defer l.unlock()
+	/* Don't double-remove.  removed is only set with the list locked,
+	so checking it here means concurrent Removes can't both pass. */
 	if e.removed {
 		return
 	}
-	/* Lock the list in case it's the head or tail. */
-	e.l.m.Lock()
-	defer e.l.m.Unlock()
-	/* Lock the previous element, this element, and the next. */
-	if e.prev != nil {
-		e.prev.m.Lock()
-		defer e.prev.m.Unlock()
+	l.unlink(e)
+}
+
+/* CompareAndRemove removes e if its value is equal to expected according to eq, or == if eq is nil, and returns whether it did.  The comparison and removal are atomic, so e can't be changed with SetValue in between.  eq is called with e and the list locked, so it mustn't use either.  If e has already been removed, CompareAndRemove returns false. */
+func (e *Element) CompareAndRemove(expected interface{}, eq func(a, b interface{}) bool) bool {
+	if eq == nil {
+		eq = equal
 	}
-	e.m.Lock()
-	defer e.m.Unlock()
-	if e.next != nil {
-		e.next.m.Lock()
-		defer e.next.m.Unlock()
+	l := e.lockList()
+	defer l.unlock()
+	if e.removed {
+		return false
 	}
-	/* Mark the removal, decrase the element count. */
-	e.removed = true
-	e.l.size--
-	/* If it's the only item, empty the list. */
-	if nil == e.prev && e.next == nil {
-		e.l.head = nil
-		e.l.tail = nil
+	/* Hold e's lock from the comparison through the removal. */
+	defer lockElements(e.prev, e, e.next)()
+	if !eq(e.value, expected) {
+		return false
+	}
+	l.unlinkLocked(e)
+	return true
+}
+
+/* InsertAfter inserts a value into the list just after e and returns the generated Element in O(1) time.  If e has been removed or the list is bounded and full, InsertAfter returns nil. */
+func (e *Element) InsertAfter(v interface{}) *Element {
+	/* Lock the list first, same as Remove. */
+	l := e.lockList()
+	defer l.unlock()
+	if e.removed || l.full() {
+		return nil
+	}
+	n := &Element{value: v, l: l}
+	l.insertAfter(n, e)
+	return n
+}
+
+/* InsertBefore inserts a value into the list just before e and returns the generated Element in O(1) time.  If e has been removed or the list is bounded and full, InsertBefore returns nil. */
+func (e *Element) InsertBefore(v interface{}) *Element {
+	/* Lock the list first, same as Remove. */
+	l := e.lockList()
+	defer l.unlock()
+	if e.removed || l.full() {
+		return nil
+	}
+	n := &Element{value: v, l: l}
+	/* Locks e.prev then e, in list order. */
+	l.insertAfter(n, e.prev)
+	return n
+}
+
+/* Replace removes e and puts a new element holding v in its place, under one lock, and returns the new element.  Unlike SetValue, this gives a fresh element, not marked for removal, which callers holding e won't see.  If e has already been removed, Replace returns nil. */
+func (e *Element) Replace(v interface{}) *Element {
+	l := e.lockList()
+	defer l.unlock()
+	if e.removed {
+		return nil
+	}
+	n := &Element{value: v, l: l}
+	prev := e.prev
+	l.unlink(e)
+	l.insertAfter(n, prev)
+	return n
+}
+
+/* MoveToFront moves e to the front of the list in O(1) time.  Nothing happens if e is already at the front or has been removed. */
+func (e *Element) MoveToFront() {
+	l := e.lockList()
+	defer l.unlock()
+	if e.removed || e == l.head {
 		return
 	}
-	/* If it's the head, the next element becomes the new head. */
-	if e.prev == nil {
-		e.l.head = e.next
-		e.next.prev = nil
+	l.move(e, nil)
+}
+
+/* MoveToBack moves e to the back of the list in O(1) time.  Nothing happens if e is already at the back or has been removed. */
+func (e *Element) MoveToBack() {
+	l := e.lockList()
+	defer l.unlock()
+	if e.removed || e == l.tail {
 		return
 	}
-	/* If it's the tail, the previous element becomes the new tail. */
-	if e.next == nil {
-		e.l.tail = e.prev
-		e.prev.next = nil
+	l.move(e, l.tail)
+}
+
+/* MoveBefore moves e to just before target in O(1) time.  Nothing happens if e and target are the same element, either has been removed, or they're in different lists. */
+func (e *Element) MoveBefore(target *Element) {
+	l := e.lockList()
+	defer l.unlock()
+	if e == target || e.removed || target.list() != l || target.removed ||
+		e == target.prev {
 		return
 	}
-	/* If it's an internal element, unlink it from both sides. */
-	e.prev.next = e.next
-	e.next.prev = e.prev
+	l.move(e, target.prev)
+}
+
+/* MoveAfter moves e to just after target in O(1) time.  Nothing happens if e and target are the same element, either has been removed, or they're in different lists. */
+func (e *Element) MoveAfter(target *Element) {
+	l := e.lockList()
+	defer l.unlock()
+	if e == target || e.removed || target.list() != l || target.removed ||
+		e == target.next {
+		return
+	}
+	l.move(e, target)
+}
+
+/* Iterator steps through the elements of a list not marked for removal.  Iterators are made with List.Iterator or List.ReverseIterator.  The list isn't locked between calls to Next, but Iterators are fail-fast: if the list's structure changes after the Iterator is made, Next returns false and Err returns ErrModified. */
+type Iterator struct {
+	l       *List    /* List being iterated */
+	version uint64   /* List's version when the Iterator was made */
+	e       *Element /* Current element */
+	next    *Element /* Element after e when e became current */
+	back    bool     /* Iterating from tail to head */
+	started bool     /* Whether e is in use yet */
+	err     error    /* Why iteration stopped early */
+}
+
+/* Iterator returns an Iterator which starts at the element which is currently the head of the list.  Call its Next method to move to the first element. */
+func (l *List) Iterator() *Iterator {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	return &Iterator{l: l, version: l.Version(), e: live(l.head)}
+}
+
+/* ReverseIterator is like Iterator, but the Iterator starts at the tail of the list and its Next method moves towards the head. */
+func (l *List) ReverseIterator() *Iterator {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	return &Iterator{l: l, version: l.Version(), e: liveBack(l.tail), back: true}
+}
+
+/* Next moves the iterator to the next element and returns true, or returns false if there are no more elements or the list has changed since the iterator was made, in which case Err returns ErrModified. */
+func (it *Iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if it.l.Version() != it.version {
+		it.err = ErrModified
+		it.e = nil
+		return false
+	}
+	/* The first call moves to the head, or tail. */
+	if !it.started {
+		it.started = true
+	} else if it.e != nil {
+		/* Starting again would visit elements twice, and the list
+		must have changed anyway, which is checked below. */
+		it.e = step(nil, it.e, it.next, it.back)
+	}
+	if it.e == nil && it.l.Version() != it.version {
+		it.err = ErrModified
+		return false
+	}
+	if it.e != nil {
+		it.next = it.e.neighbor(it.back)
+	}
+	return it.e != nil
+}
+
+/* Value returns the value of the iterator's current element, or nil if there isn't one, i.e. if Next hasn't returned true or has since returned false. */
+func (it *Iterator) Value() interface{} {
+	e := it.Element()
+	if e == nil {
+		return nil
+	}
+	return e.Value()
+}
+
+/* Err returns ErrModified if Next returned false because the list changed, or nil otherwise. */
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+/* Element returns the iterator's current element, or nil if Next hasn't returned true. */
+func (it *Iterator) Element() *Element {
+	if !it.started {
+		return nil
+	}
+	return it.e
 }
@@ -0,0 +1,2620 @@
+package tslist
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+/* forward returns l's values, walking from Head with Next. */
+func forward(l *List) string {
+	var vs []interface{}
+	for e := l.Head(); e != nil; e = e.Next() {
+		vs = append(vs, e.Value())
+	}
+	return fmt.Sprint(vs)
+}
+
+/* backward returns l's values, walking from Tail with Prev. */
+func backward(l *List) string {
+	var vs []interface{}
+	for e := l.Tail(); e != nil; e = e.Prev() {
+		vs = append(vs, e.Value())
+	}
+	return fmt.Sprint(vs)
+}
+
+/* ints makes a list holding 1..n. */
+func ints(n int) *List {
+	l := New()
+	for i := 1; i <= n; i++ {
+		l.Append(i)
+	}
+	return l
+}
+
+/* checkOrder fails t if l doesn't hold want in both directions. */
+func checkOrder(t *testing.T, l *List, want string) {
+	t.Helper()
+	if got := forward(l); got != want {
+		t.Fatalf("forward: got %v, want %v", got, want)
+	}
+	var rev []interface{}
+	vs := l.ToSlice()
+	for i := len(vs) - 1; i >= 0; i-- {
+		rev = append(rev, vs[i])
+	}
+	if got := backward(l); got != fmt.Sprint(rev) {
+		t.Fatalf("backward: got %v, want reverse of %v", got, want)
+	}
+}
+
+func TestSwap(t *testing.T) {
+	l := ints(5)
+	l.Swap(l.Head(), l.Tail())
+	checkOrder(t, l, "[5 2 3 4 1]")
+	if l.Len() != 5 {
+		t.Fatalf("Len: got %v, want 5", l.Len())
+	}
+	/* Adjacent, in both orders. */
+	l.Swap(l.Get(1), l.Get(2))
+	checkOrder(t, l, "[5 3 2 4 1]")
+	l.Swap(l.Get(3), l.Get(2))
+	checkOrder(t, l, "[5 3 4 2 1]")
+	/* Adjacent at the ends. */
+	l.Swap(l.Get(0), l.Get(1))
+	checkOrder(t, l, "[3 5 4 2 1]")
+	l.Swap(l.Get(4), l.Get(3))
+	checkOrder(t, l, "[3 5 4 1 2]")
+	/* Same element and removed elements are no-ops. */
+	l.Swap(l.Head(), l.Head())
+	e := l.Tail()
+	e.Remove()
+	l.Swap(l.Head(), e)
+	checkOrder(t, l, "[3 5 4 1]")
+}
+
+func TestLen(t *testing.T) {
+	for n := 0; n <= 5; n++ {
+		l := New()
+		es := make([]*Element, 0, n)
+		for i := 0; i < n; i++ {
+			es = append(es, l.Append(i))
+		}
+		if l.Len() != n {
+			t.Fatalf("Len after %v appends: got %v", n, l.Len())
+		}
+		for _, e := range es {
+			e.Remove()
+		}
+		if l.Len() != 0 {
+			t.Fatalf("Len after removing %v: got %v", n, l.Len())
+		}
+	}
+}
+
+func TestRemoveMarked(t *testing.T) {
+	l := ints(8)
+	es := []*Element{l.Get(0), l.Get(3), l.Get(7)}
+	for _, e := range es {
+		e.RemoveMark()
+	}
+	l.RemoveMarked()
+	if l.Len() != 5 {
+		t.Fatalf("Len: got %v, want 5", l.Len())
+	}
+	checkOrder(t, l, "[2 3 5 6 7]")
+}
+
+func TestTail(t *testing.T) {
+	l := New()
+	if l.Tail() != nil {
+		t.Fatalf("Tail of empty list not nil")
+	}
+	l = ints(3)
+	if v := l.Tail().Value(); v != 3 {
+		t.Fatalf("Tail: got %v, want 3", v)
+	}
+	l.Tail().RemoveMark()
+	if v := l.Tail().Value(); v != 2 {
+		t.Fatalf("Tail after marking: got %v, want 2", v)
+	}
+}
+
+func TestPrev(t *testing.T) {
+	l := New()
+	es := make([]*Element, 100)
+	for i := range es {
+		es[i] = l.Append(i)
+	}
+	if es[0].Prev() != nil {
+		t.Fatalf("Prev of head not nil")
+	}
+	es[4].RemoveMark()
+	es[5].RemoveMark()
+	if p := es[6].Prev(); p != es[3] {
+		t.Fatalf("Prev past marks: got %v, want 3", p)
+	}
+	/* Walk backwards while another goroutine marks and removes. */
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 10; i < 90; i++ {
+			es[i].RemoveMark()
+			if i%3 == 0 {
+				es[i].Remove()
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 50; j++ {
+			for e := es[99]; e != nil; e = e.Prev() {
+			}
+		}
+	}()
+	wg.Wait()
+	if p := es[90].Prev(); p != es[9] {
+		t.Fatalf("Prev after marking: got %v, want 9", p)
+	}
+}
+
+func TestPushFront(t *testing.T) {
+	l := New()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				if g%2 == 0 {
+					l.PushFront(i)
+				} else {
+					l.PushBack(i)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	n := 0
+	for e := l.Head(); e != nil; e = e.Next() {
+		n++
+	}
+	if n != 1600 || l.Len() != 1600 {
+		t.Fatalf("got %v elements, Len %v, want 1600", n, l.Len())
+	}
+	n = 0
+	for e := l.Tail(); e != nil; e = e.Prev() {
+		n++
+	}
+	if n != 1600 {
+		t.Fatalf("got %v elements backwards, want 1600", n)
+	}
+}
+
+func TestInsertAfter(t *testing.T) {
+	l := New()
+	a := l.Append(1)
+	c := l.Append(3)
+	a.InsertAfter(2)
+	c.InsertAfter(4)
+	checkOrder(t, l, "[1 2 3 4]")
+	if l.Len() != 4 {
+		t.Fatalf("Len: got %v, want 4", l.Len())
+	}
+	c.Remove()
+	if c.InsertAfter(5) != nil {
+		t.Fatalf("InsertAfter on removed element not nil")
+	}
+	checkOrder(t, l, "[1 2 4]")
+}
+
+func TestInsertBefore(t *testing.T) {
+	l := ints(3)
+	l.Get(1).InsertBefore(0)
+	l.Head().InsertBefore(-1)
+	checkOrder(t, l, "[-1 1 0 2 3]")
+}
+
+func TestToSlice(t *testing.T) {
+	if vs := New().ToSlice(); vs == nil || len(vs) != 0 {
+		t.Fatalf("empty list: got %#v, want empty non-nil slice", vs)
+	}
+	l := ints(5)
+	l.Head().RemoveMark()
+	l.Get(1).RemoveMark()
+	if got := fmt.Sprint(l.ToSlice()); got != "[2 4 5]" {
+		t.Fatalf("got %v, want [2 4 5]", got)
+	}
+}
+
+func TestFromSlice(t *testing.T) {
+	for _, vs := range [][]interface{}{nil, {}, {1, "two", 3.0}} {
+		l := FromSlice(vs)
+		if l.Len() != len(vs) {
+			t.Fatalf("Len: got %v, want %v", l.Len(), len(vs))
+		}
+		if got, want := fmt.Sprint(l.ToSlice()), fmt.Sprint(vs); got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	l := ints(5)
+	var seen []interface{}
+	l.Range(func(e *Element) bool {
+		seen = append(seen, e.Value())
+		if e.Value().(int)%2 == 0 {
+			e.RemoveMark()
+		}
+		return e.Value() != 4
+	})
+	if got := fmt.Sprint(seen); got != "[1 2 3 4]" {
+		t.Fatalf("visited %v, want [1 2 3 4]", got)
+	}
+	checkOrder(t, l, "[1 3 5]")
+}
+
+func TestFind(t *testing.T) {
+	l := ints(6)
+	even := func(v interface{}) bool { return v.(int)%2 == 0 }
+	if e := l.Find(even); e == nil || e.Value() != 2 {
+		t.Fatalf("got %v, want 2", e)
+	}
+	l.Get(1).RemoveMark()
+	if e := l.Find(even); e == nil || e.Value() != 4 {
+		t.Fatalf("after marking 2: got %v, want 4", e)
+	}
+	if e := l.Find(func(v interface{}) bool { return v == 7 }); e != nil {
+		t.Fatalf("got %v, want nil", e)
+	}
+}
+
+func TestContains(t *testing.T) {
+	if New().Contains(1) {
+		t.Fatalf("empty list contains 1")
+	}
+	l := FromSlice([]interface{}{1, "a"})
+	if !l.Contains("a") || !l.Contains(1) {
+		t.Fatalf("missing values")
+	}
+	if l.Contains(2) || l.Contains("1") {
+		t.Fatalf("found absent values")
+	}
+}
+
+func TestClear(t *testing.T) {
+	l := ints(3)
+	m := l.Get(1)
+	l.Clear()
+	if l.Len() != 0 || l.Head() != nil || l.Tail() != nil {
+		t.Fatalf("list not empty after Clear")
+	}
+	if m.Next() != nil || m.Prev() != nil {
+		t.Fatalf("cleared element still linked")
+	}
+	/* Removing a cleared element mustn't touch the list. */
+	m.Remove()
+	l.Append(4)
+	checkOrder(t, l, "[4]")
+	if l.Len() != 1 {
+		t.Fatalf("Len: got %v, want 1", l.Len())
+	}
+}
+
+func TestPopFront(t *testing.T) {
+	if _, ok := New().PopFront(); ok {
+		t.Fatalf("PopFront on empty list returned ok")
+	}
+	const producers, perProducer = 4, 1000
+	l := New()
+	var (
+		mu        sync.Mutex
+		seen      = make([]int, producers*perProducer)
+		pwg, cwg  sync.WaitGroup
+		producing = make(chan struct{})
+	)
+	for p := 0; p < producers; p++ {
+		pwg.Add(1)
+		go func(p int) {
+			defer pwg.Done()
+			for i := 0; i < perProducer; i++ {
+				l.Append(p*perProducer + i)
+			}
+		}(p)
+	}
+	for c := 0; c < 4; c++ {
+		cwg.Add(1)
+		go func() {
+			defer cwg.Done()
+			for {
+				v, ok := l.PopFront()
+				if ok {
+					mu.Lock()
+					seen[v.(int)]++
+					mu.Unlock()
+					continue
+				}
+				/* Only give up once nothing more is coming. */
+				select {
+				case <-producing:
+					if l.Len() == 0 {
+						return
+					}
+				default:
+				}
+			}
+		}()
+	}
+	pwg.Wait()
+	close(producing)
+	cwg.Wait()
+	for v, n := range seen {
+		if n != 1 {
+			t.Fatalf("value %v popped %v times", v, n)
+		}
+	}
+}
+
+func TestPopBack(t *testing.T) {
+	l := ints(3)
+	l.Tail().RemoveMark()
+	if v, ok := l.PopBack(); !ok || v != 2 {
+		t.Fatalf("got %v %v, want 2 true", v, ok)
+	}
+	l.PushFront(0)
+	if v, ok := l.PopBack(); !ok || v != 1 {
+		t.Fatalf("got %v %v, want 1 true", v, ok)
+	}
+	if v, ok := l.PopBack(); !ok || v != 0 {
+		t.Fatalf("got %v %v, want 0 true", v, ok)
+	}
+	if _, ok := l.PopBack(); ok {
+		t.Fatalf("PopBack with only marked elements returned ok")
+	}
+}
+
+func TestPopFrontWait(t *testing.T) {
+	l := New()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		l.Append(7)
+	}()
+	if v, err := l.PopFrontWait(context.Background()); err != nil || v != 7 {
+		t.Fatalf("got %v %v, want 7", v, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if _, err := l.PopFrontWait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	/* A non-empty list doesn't wait, even with a done context. */
+	l.Append(8)
+	if v, err := l.PopFrontWait(ctx); err != nil || v != 8 {
+		t.Fatalf("got %v %v, want 8", v, err)
+	}
+}
+
+func TestSetValue(t *testing.T) {
+	e := New().Append(0)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if _, ok := e.Value().(int); !ok {
+					t.Errorf("Value not an int")
+					return
+				}
+			}
+		}()
+	}
+	for j := 1; j <= 1000; j++ {
+		e.SetValue(j)
+	}
+	wg.Wait()
+	if old := e.SwapValue(-1); old != 1000 {
+		t.Fatalf("SwapValue: got %v, want 1000", old)
+	}
+	if e.Value() != -1 {
+		t.Fatalf("Value: got %v, want -1", e.Value())
+	}
+}
+
+func TestRemoveConcurrent(t *testing.T) {
+	l := ints(3)
+	m := l.Get(1)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.Remove()
+		}()
+	}
+	wg.Wait()
+	if l.Len() != 2 {
+		t.Fatalf("Len: got %v, want 2", l.Len())
+	}
+	checkOrder(t, l, "[1 3]")
+}
+
+func BenchmarkNextMarked(b *testing.B) {
+	l := New()
+	for i := 0; i < 10000; i++ {
+		e := l.Append(i)
+		if i%100 != 0 {
+			e.RemoveMark()
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for e := l.Head(); e != nil; e = e.Next() {
+		}
+	}
+}
+
+func TestReverse(t *testing.T) {
+	l := ints(5)
+	h := l.Head()
+	l.Reverse()
+	checkOrder(t, l, "[5 4 3 2 1]")
+	if got := backward(l); got != "[1 2 3 4 5]" {
+		t.Fatalf("backward: got %v", got)
+	}
+	if l.Tail() != h || l.Len() != 5 {
+		t.Fatalf("old head isn't the tail, or Len changed")
+	}
+	New().Reverse()
+}
+
+func TestGet(t *testing.T) {
+	l := ints(5)
+	n := l.Len()
+	for _, c := range []struct {
+		i, want int
+	}{{0, 1}, {n - 1, 5}, {-1, 5}, {-n, 1}} {
+		if e := l.Get(c.i); e == nil || e.Value() != c.want {
+			t.Fatalf("Get(%v): got %v, want %v", c.i, e, c.want)
+		}
+	}
+	for _, i := range []int{n, -n - 1} {
+		if e := l.Get(i); e != nil {
+			t.Fatalf("Get(%v): got %v, want nil", i, e)
+		}
+	}
+	l.Get(2).RemoveMark()
+	if e := l.Get(2); e.Value() != 4 {
+		t.Fatalf("Get past a mark: got %v, want 4", e)
+	}
+}
+
+func TestIndexOf(t *testing.T) {
+	l := FromSlice([]interface{}{1, 2, 3, 2})
+	if i := l.IndexOf(2); i != 1 {
+		t.Fatalf("duplicate: got %v, want 1", i)
+	}
+	if i := l.IndexOf(9); i != -1 {
+		t.Fatalf("absent: got %v, want -1", i)
+	}
+	l.Head().RemoveMark()
+	if i := l.IndexOf(3); i != 1 {
+		t.Fatalf("after marking head: got %v, want 1", i)
+	}
+}
+
+func TestClone(t *testing.T) {
+	l := ints(3)
+	c := l.Clone()
+	c.Head().Remove()
+	c.Append(4)
+	checkOrder(t, l, "[1 2 3]")
+	checkOrder(t, c, "[2 3 4]")
+}
+
+func TestMoveToFront(t *testing.T) {
+	l := ints(3)
+	l.Get(1).MoveToFront()
+	checkOrder(t, l, "[2 1 3]")
+	l.Tail().MoveToFront()
+	checkOrder(t, l, "[3 2 1]")
+	l.Head().MoveToFront()
+	checkOrder(t, l, "[3 2 1]")
+	if l.Len() != 3 {
+		t.Fatalf("Len: got %v, want 3", l.Len())
+	}
+}
+
+func TestMoveToBack(t *testing.T) {
+	l := ints(3)
+	second := l.Get(1)
+	l.Head().MoveToBack()
+	checkOrder(t, l, "[2 3 1]")
+	if l.Head() != second {
+		t.Fatalf("old second element isn't the head")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	b, err := json.Marshal(FromSlice([]interface{}{1, "a", true}))
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if string(b) != `[1,"a",true]` {
+		t.Fatalf("Marshal: got %s", b)
+	}
+	l := ints(2)
+	if err := json.Unmarshal(b, l); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	/* Numbers come back as float64. */
+	if vs := l.ToSlice(); len(vs) != 3 || vs[0] != 1.0 || vs[1] != "a" || vs[2] != true {
+		t.Fatalf("Unmarshal: got %#v", vs)
+	}
+	if err := json.Unmarshal([]byte(`{}`), l); err == nil {
+		t.Fatalf("Unmarshal of an object didn't fail")
+	}
+}
+
+type gobPayload struct {
+	N int
+	S string
+}
+
+func TestGob(t *testing.T) {
+	gob.Register(gobPayload{})
+	l := FromSlice([]interface{}{gobPayload{1, "a"}, gobPayload{2, "b"}})
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(l); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	d := New()
+	if err := gob.NewDecoder(&buf).Decode(d); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !d.Equal(l, nil) {
+		t.Fatalf("got %v, want %v", d, l)
+	}
+}
+
+func TestString(t *testing.T) {
+	l := FromSlice([]interface{}{1, "a", 2.5})
+	if s := l.String(); s != "[1 a 2.5]" {
+		t.Fatalf("List: got %q", s)
+	}
+	if s := fmt.Sprint(l.Get(1)); s != "a" {
+		t.Fatalf("Element: got %q", s)
+	}
+	if s := New().String(); s != "[]" {
+		t.Fatalf("empty List: got %q", s)
+	}
+}
+
+func TestForEach(t *testing.T) {
+	l := ints(5)
+	stop := errors.New("stop")
+	var seen []interface{}
+	err := l.ForEach(func(v interface{}) error {
+		seen = append(seen, v)
+		if v == 3 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Fatalf("got error %v, want %v", err, stop)
+	}
+	if got := fmt.Sprint(seen); got != "[1 2 3]" {
+		t.Fatalf("visited %v, want [1 2 3]", got)
+	}
+	if err := l.ForEach(func(interface{}) error { return nil }); err != nil {
+		t.Fatalf("got error %v, want nil", err)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	l := ints(6)
+	f := l.Filter(func(v interface{}) bool { return v.(int)%2 == 0 })
+	checkOrder(t, f, "[2 4 6]")
+	checkOrder(t, l, "[1 2 3 4 5 6]")
+}
+
+func TestMap(t *testing.T) {
+	l := ints(3)
+	m := l.Map(func(v interface{}) interface{} { return fmt.Sprint("n", v) })
+	checkOrder(t, m, "[n1 n2 n3]")
+	checkOrder(t, l, "[1 2 3]")
+}
+
+func TestReduce(t *testing.T) {
+	sum := ints(4).Reduce(0, func(acc, v interface{}) interface{} {
+		return acc.(int) + v.(int)
+	})
+	if sum != 10 {
+		t.Fatalf("got %v, want 10", sum)
+	}
+	if v := New().Reduce("x", nil); v != "x" {
+		t.Fatalf("empty list: got %v, want x", v)
+	}
+}
+
+func TestSort(t *testing.T) {
+	l := New()
+	for _, v := range rand.Perm(10) {
+		l.Append(v)
+	}
+	e := l.Head()
+	l.Sort(func(a, b interface{}) bool { return a.(int) < b.(int) })
+	checkOrder(t, l, "[0 1 2 3 4 5 6 7 8 9]")
+	if l.Get(e.Value().(int)) != e {
+		t.Fatalf("element didn't move with its value")
+	}
+	/* Equal keys keep their order. */
+	type kv struct{ k, v int }
+	s := FromSlice([]interface{}{kv{1, 0}, kv{0, 1}, kv{1, 2}, kv{0, 3}})
+	s.Sort(func(a, b interface{}) bool { return a.(kv).k < b.(kv).k })
+	checkOrder(t, s, "[{0 1} {0 3} {1 0} {1 2}]")
+	New().Sort(nil)
+}
+
+func TestConcat(t *testing.T) {
+	a, b := ints(3), FromSlice([]interface{}{4, 5, 6})
+	a.Concat(b)
+	checkOrder(t, a, "[1 2 3 4 5 6]")
+	checkOrder(t, b, "[4 5 6]")
+	b.Concat(b)
+	b.Concat(nil)
+	checkOrder(t, b, "[4 5 6 4 5 6]")
+	if a.Len() != 6 || b.Len() != 6 {
+		t.Fatalf("Len: got %v and %v, want 6", a.Len(), b.Len())
+	}
+}
+
+func TestSplice(t *testing.T) {
+	l := FromSlice([]interface{}{0, 1, 2, 3, 4, 5})
+	l.Splice(l.Get(1), l.Get(2), l.Get(4))
+	checkOrder(t, l, "[0 3 4 1 2 5]")
+	l.Splice(l.Get(4), l.Get(5), nil)
+	checkOrder(t, l, "[2 5 0 3 4 1]")
+	l.Splice(l.Get(0), l.Get(0), l.Tail())
+	checkOrder(t, l, "[5 0 3 4 1 2]")
+	l.Splice(l.Head(), l.Tail(), nil)
+	checkOrder(t, l, "[5 0 3 4 1 2]")
+	/* after in the run is refused. */
+	l.Splice(l.Get(1), l.Get(3), l.Get(3))
+	checkOrder(t, l, "[5 0 3 4 1 2]")
+}
+
+func TestEqual(t *testing.T) {
+	a, b := ints(3), ints(3)
+	if !a.Equal(b, nil) || !b.Equal(a, nil) || !a.Equal(a, nil) {
+		t.Fatalf("equal lists not Equal")
+	}
+	b.Append(4)
+	if a.Equal(b, nil) || b.Equal(a, nil) {
+		t.Fatalf("different lengths Equal")
+	}
+	b.Tail().RemoveMark()
+	if !a.Equal(b, nil) {
+		t.Fatalf("marked element counted")
+	}
+	b.Head().SetValue(9)
+	if a.Equal(b, nil) {
+		t.Fatalf("different values Equal")
+	}
+	if !a.Equal(b, func(x, y interface{}) bool { return true }) {
+		t.Fatalf("eq not used")
+	}
+	if a.Equal(nil, nil) {
+		t.Fatalf("list Equal to nil")
+	}
+}
+
+func TestRemoveIf(t *testing.T) {
+	l := ints(6)
+	n := l.RemoveIf(func(v interface{}) bool { return v.(int)%2 == 1 })
+	if n != 3 || l.Len() != 3 {
+		t.Fatalf("removed %v, Len %v, want 3 and 3", n, l.Len())
+	}
+	checkOrder(t, l, "[2 4 6]")
+}
+
+func TestLiveLen(t *testing.T) {
+	l := ints(4)
+	e := l.Get(1)
+	e.RemoveMark()
+	e.RemoveMark()
+	if l.Len() != 4 || l.LiveLen() != 3 {
+		t.Fatalf("after mark: Len %v LiveLen %v, want 4 and 3", l.Len(), l.LiveLen())
+	}
+	l.RemoveMarked()
+	if l.Len() != 3 || l.LiveLen() != 3 {
+		t.Fatalf("after sweep: Len %v LiveLen %v, want 3 and 3", l.Len(), l.LiveLen())
+	}
+	/* Marking a removed element doesn't count. */
+	e.RemoveMark()
+	if l.LiveLen() != 3 {
+		t.Fatalf("removed element counted: LiveLen %v", l.LiveLen())
+	}
+	l.Clear()
+	if l.LiveLen() != 0 {
+		t.Fatalf("after Clear: LiveLen %v", l.LiveLen())
+	}
+}
+
+/* cancelAfter is a context which is cancelled after n calls to Err. */
+type cancelAfter struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfter) Err() error {
+	if c.n <= 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestRemoveMarkedContext(t *testing.T) {
+	l := ints(10)
+	for e := l.Head(); e != nil; e = e.Next() {
+		if e.Value().(int)%2 == 0 {
+			e.RemoveMark()
+		}
+	}
+	ctx := &cancelAfter{Context: context.Background(), n: 5}
+	if err := l.RemoveMarkedContext(ctx); err != context.Canceled {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	/* Partly swept, but still intact. */
+	if l.Len() != 8 {
+		t.Fatalf("Len: got %v, want 8", l.Len())
+	}
+	checkOrder(t, l, "[1 3 5 7 9]")
+	if err := l.RemoveMarkedContext(context.Background()); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	if l.Len() != 5 {
+		t.Fatalf("Len after full sweep: got %v, want 5", l.Len())
+	}
+}
+
+func TestBounded(t *testing.T) {
+	l := NewBounded(3)
+	for i := 0; i < 3; i++ {
+		if _, err := l.TryAppend(i); err != nil {
+			t.Fatalf("append %v: %v", i, err)
+		}
+	}
+	if _, err := l.TryAppend(3); err != ErrFull {
+		t.Fatalf("4th TryAppend: got %v, want ErrFull", err)
+	}
+	if _, err := l.TryPushFront(3); err != ErrFull {
+		t.Fatalf("TryPushFront: got %v, want ErrFull", err)
+	}
+	if l.Append(3) != nil || l.PushFront(3) != nil || l.Head().InsertAfter(3) != nil {
+		t.Fatalf("insert into full list didn't return nil")
+	}
+	if c := l.Clone(); c.Append(3) != nil {
+		t.Fatalf("Clone lost the bound")
+	}
+	if err := json.Unmarshal([]byte("[1,2,3,4]"), l); err != ErrFull || l.Len() != 3 {
+		t.Fatalf("Unmarshal: got %v with Len %v, want ErrFull and 3", err, l.Len())
+	}
+	/* Concurrent appends can't overfill it. */
+	b := NewBounded(50)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				b.Append(j)
+			}
+		}()
+	}
+	wg.Wait()
+	if b.Len() != 50 {
+		t.Fatalf("Len: got %v, want 50", b.Len())
+	}
+	if NewBounded(-1).Append(1) == nil {
+		t.Fatalf("negative max isn't unbounded")
+	}
+}
+
+func TestAppendUnique(t *testing.T) {
+	l := New()
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+		n  int
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := l.AppendUnique("x", nil); ok {
+				mu.Lock()
+				n++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if n != 1 || l.Len() != 1 {
+		t.Fatalf("%v inserts, Len %v, want 1 and 1", n, l.Len())
+	}
+	e, ok := l.AppendUnique("X", func(a, b interface{}) bool {
+		return fmt.Sprint(a) == fmt.Sprint(b) || a == "x"
+	})
+	if ok || e != l.Head() {
+		t.Fatalf("custom eq not used")
+	}
+}
+
+func TestIterator(t *testing.T) {
+	l := ints(4)
+	l.Get(1).RemoveMark()
+	for i := 0; i < 2; i++ {
+		var vs []interface{}
+		it := l.Iterator()
+		if it.Element() != nil || it.Value() != nil {
+			t.Fatalf("Element or Value before Next not nil")
+		}
+		for it.Next() {
+			vs = append(vs, it.Value())
+			if it.Element().Value() != it.Value() {
+				t.Fatalf("Element and Value disagree")
+			}
+		}
+		if got := fmt.Sprint(vs); got != "[1 3 4]" {
+			t.Fatalf("pass %v: got %v, want [1 3 4]", i, got)
+		}
+		if it.Next() {
+			t.Fatalf("Next after the end returned true")
+		}
+		if it.Element() != nil || it.Value() != nil {
+			t.Fatalf("Element or Value after the end not nil")
+		}
+	}
+	/* Nor after the list changes. */
+	it := l.Iterator()
+	it.Next()
+	l.Append(5)
+	if it.Next() || it.Value() != nil {
+		t.Fatalf("Value after ErrModified not nil")
+	}
+	if New().Iterator().Next() {
+		t.Fatalf("Next on an empty list returned true")
+	}
+}
+
+func TestInsertSorted(t *testing.T) {
+	less := func(a, b interface{}) bool { return a.(int) < b.(int) }
+	l := FromSlice([]interface{}{1, 3, 5})
+	for _, v := range []int{0, 4, 6, 3, 2} {
+		if e := l.InsertSorted(v, less); e == nil || e.Value() != v {
+			t.Fatalf("InsertSorted(%v): got %v", v, e)
+		}
+	}
+	checkOrder(t, l, "[0 1 2 3 3 4 5 6]")
+	/* Concurrent inserts keep the order. */
+	c := New()
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, v := range rand.Perm(50) {
+				c.InsertSorted(v, less)
+			}
+		}()
+	}
+	wg.Wait()
+	vs := c.ToSlice()
+	for i := 1; i < len(vs); i++ {
+		if less(vs[i], vs[i-1]) {
+			t.Fatalf("out of order at %v: %v", i, vs)
+		}
+	}
+}
+
+func TestMinMax(t *testing.T) {
+	less := func(a, b interface{}) bool { return a.(int) < b.(int) }
+	if _, ok := New().Min(less); ok {
+		t.Fatalf("Min of empty list returned ok")
+	}
+	if _, ok := New().Max(less); ok {
+		t.Fatalf("Max of empty list returned ok")
+	}
+	l := New()
+	for _, v := range rand.Perm(20) {
+		l.Append(v)
+	}
+	if v, ok := l.Min(less); !ok || v != 0 {
+		t.Fatalf("Min: got %v %v, want 0", v, ok)
+	}
+	if v, ok := l.Max(less); !ok || v != 19 {
+		t.Fatalf("Max: got %v %v, want 19", v, ok)
+	}
+}
+
+func TestCount(t *testing.T) {
+	l := ints(10)
+	if n := l.Count(func(v interface{}) bool { return v.(int)%2 == 0 }); n != 5 {
+		t.Fatalf("got %v, want 5", n)
+	}
+	l.Head().RemoveMark()
+	if n := l.Count(nil); n != 9 || n != l.LiveLen() {
+		t.Fatalf("nil pred: got %v, want 9", n)
+	}
+}
+
+func TestInsertAt(t *testing.T) {
+	l := ints(3)
+	for _, c := range []struct{ i, v int }{{0, 0}, {2, 9}, {5, 4}} {
+		e, err := l.InsertAt(c.i, c.v)
+		if err != nil || e.Value() != c.v {
+			t.Fatalf("InsertAt(%v): got %v %v", c.i, e, err)
+		}
+		if l.IndexOf(c.v) != c.i {
+			t.Fatalf("InsertAt(%v): ended up at %v", c.i, l.IndexOf(c.v))
+		}
+	}
+	checkOrder(t, l, "[0 1 9 2 3 4]")
+	for _, i := range []int{-1, 7} {
+		if _, err := l.InsertAt(i, 0); err != ErrIndex {
+			t.Fatalf("InsertAt(%v): got %v, want ErrIndex", i, err)
+		}
+	}
+	if _, err := New().InsertAt(0, 1); err != nil {
+		t.Fatalf("InsertAt(0) on empty list: %v", err)
+	}
+}
+
+func TestRemoveAt(t *testing.T) {
+	l := ints(5)
+	for _, c := range []struct{ i, want int }{{0, 1}, {1, 3}, {2, 5}} {
+		if v, err := l.RemoveAt(c.i); err != nil || v != c.want {
+			t.Fatalf("RemoveAt(%v): got %v %v, want %v", c.i, v, err, c.want)
+		}
+	}
+	checkOrder(t, l, "[2 4]")
+	if l.Len() != 2 {
+		t.Fatalf("Len: got %v, want 2", l.Len())
+	}
+	for _, i := range []int{-1, 2} {
+		if _, err := l.RemoveAt(i); err != ErrIndex {
+			t.Fatalf("RemoveAt(%v): got %v, want ErrIndex", i, err)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	less := func(a, b interface{}) bool { return a.(int) < b.(int) }
+	a, b := FromSlice([]interface{}{1, 3, 5}), FromSlice([]interface{}{2, 4, 6, 8})
+	checkOrder(t, Merge(a, b, less), "[1 2 3 4 5 6 8]")
+	checkOrder(t, a, "[1 3 5]")
+	checkOrder(t, b, "[2 4 6 8]")
+	checkOrder(t, Merge(a, a, less), "[1 1 3 3 5 5]")
+	checkOrder(t, Merge(New(), b, less), "[2 4 6 8]")
+}
+
+func TestElementList(t *testing.T) {
+	l := ints(2)
+	e := l.Head()
+	if e.List() != l {
+		t.Fatalf("wrong list")
+	}
+	e.Remove()
+	if e.List() != nil {
+		t.Fatalf("removed element still has a list")
+	}
+}
+
+func TestIsEmpty(t *testing.T) {
+	l := New()
+	if !l.IsEmpty() {
+		t.Fatalf("new list not empty")
+	}
+	e := l.Append(1)
+	if l.IsEmpty() {
+		t.Fatalf("list with an element empty")
+	}
+	e.Remove()
+	if !l.IsEmpty() {
+		t.Fatalf("list not empty after removal")
+	}
+}
+
+func TestAppendAll(t *testing.T) {
+	l := ints(1)
+	es := l.AppendAll([]interface{}{2, 3, 4})
+	if len(es) != 3 || es[0].Value() != 2 || es[2] != l.Tail() {
+		t.Fatalf("wrong elements returned: %v", es)
+	}
+	checkOrder(t, l, "[1 2 3 4]")
+	if es := NewBounded(2).AppendAll([]interface{}{1, 2, 3}); len(es) != 2 {
+		t.Fatalf("bounded: got %v elements, want 2", len(es))
+	}
+}
+
+/* bulk is the number of values appended by the bulk append benchmarks. */
+const bulk = 1000
+
+func BenchmarkAppendAll(b *testing.B) {
+	vs := make([]interface{}, bulk)
+	for i := range vs {
+		vs[i] = i
+	}
+	for i := 0; i < b.N; i++ {
+		New().AppendAll(vs)
+	}
+}
+
+func BenchmarkAppendLoop(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		l := New()
+		for j := 0; j < bulk; j++ {
+			l.Append(j)
+		}
+	}
+}
+
+func TestDrain(t *testing.T) {
+	l := ints(5)
+	var vs []interface{}
+	for v := range l.Drain(context.Background(), false) {
+		vs = append(vs, v)
+	}
+	if got := fmt.Sprint(vs); got != "[1 2 3 4 5]" || l.Len() != 0 {
+		t.Fatalf("got %v with Len %v, want [1 2 3 4 5] and 0", got, l.Len())
+	}
+	/* Waiting for more, until cancelled. */
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := l.Drain(ctx, true)
+	l.Append(6)
+	if v := <-ch; v != 6 {
+		t.Fatalf("got %v, want 6", v)
+	}
+	cancel()
+	for range ch {
+	}
+
+	/* A value popped but not received when ctx is done goes back. */
+	l = NewBounded(2)
+	l.AppendAll([]interface{}{1, 2})
+	ctx, cancel = context.WithCancel(context.Background())
+	ch = l.Drain(ctx, true)
+	if v := <-ch; v != 1 {
+		t.Fatalf("got %v, want 1", v)
+	}
+	/* Give Drain time to pop 2, and fill the list behind it. */
+	time.Sleep(50 * time.Millisecond)
+	l.AppendAll([]interface{}{3, 4})
+	/* Nobody's receiving, so Drain can only see ctx is done. */
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	for v := range ch {
+		t.Fatalf("got %v after cancelling", v)
+	}
+	checkOrder(t, l, "[2 3 4]")
+}
+
+func TestHeadTailMarked(t *testing.T) {
+	l := ints(3)
+	l.Head().RemoveMark()
+	if v := l.Head().Value(); v != 2 {
+		t.Fatalf("Head: got %v, want 2", v)
+	}
+	l.Tail().RemoveMark()
+	if v := l.Tail().Value(); v != 2 {
+		t.Fatalf("Tail: got %v, want 2", v)
+	}
+	l.Head().RemoveMark()
+	if l.Head() != nil || l.Tail() != nil {
+		t.Fatalf("Head or Tail of all-marked list not nil")
+	}
+}
+
+func TestCompareAndRemove(t *testing.T) {
+	l := ints(3)
+	e := l.Get(1)
+	if e.CompareAndRemove(3, nil) {
+		t.Fatalf("removed on mismatch")
+	}
+	/* Race a setter against several removers; whoever matches wins. */
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		removed int
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i == 0 {
+				e.SetValue(20)
+				return
+			}
+			if e.CompareAndRemove(2+18*(i%2), nil) {
+				mu.Lock()
+				removed++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if removed != 1 || l.Len() != 2 {
+		t.Fatalf("removed %v times, Len %v, want 1 and 2", removed, l.Len())
+	}
+	checkOrder(t, l, "[1 3]")
+	if e.CompareAndRemove(e.Value(), nil) {
+		t.Fatalf("removed a removed element")
+	}
+}
+
+func TestStats(t *testing.T) {
+	l := ints(5)
+	l.Head().RemoveMark()
+	l.Tail().RemoveMark()
+	if total, marked, live := l.Stats(); total != 5 || marked != 2 || live != 3 {
+		t.Fatalf("got %v %v %v, want 5 2 3", total, marked, live)
+	}
+	l.RemoveMarked()
+	if total, marked, live := l.Stats(); total != 3 || marked != 0 || live != 3 {
+		t.Fatalf("after sweep: got %v %v %v, want 3 0 3", total, marked, live)
+	}
+}
+
+func TestDeleteValue(t *testing.T) {
+	l := FromSlice([]interface{}{1, 2, 3, 2})
+	if !l.DeleteValue(1, nil) {
+		t.Fatalf("didn't delete 1")
+	}
+	if !l.DeleteValue(2, nil) {
+		t.Fatalf("didn't delete 2")
+	}
+	checkOrder(t, l, "[3 2]")
+	if l.DeleteValue(9, nil) || l.Len() != 2 {
+		t.Fatalf("deleted an absent value")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	l := ints(4)
+	for _, c := range []struct {
+		n    int
+		want string
+	}{
+		{1, "[2 3 4 1]"},
+		{-1, "[1 2 3 4]"},
+		{6, "[3 4 1 2]"},
+		{-7, "[4 1 2 3]"},
+		{4, "[4 1 2 3]"},
+		{0, "[4 1 2 3]"},
+	} {
+		l.Rotate(c.n)
+		checkOrder(t, l, c.want)
+	}
+	one := ints(1)
+	one.Rotate(3)
+	checkOrder(t, one, "[1]")
+}
+
+func TestFindLast(t *testing.T) {
+	l := ints(6)
+	even := func(v interface{}) bool { return v.(int)%2 == 0 }
+	if e := l.FindLast(even); e == nil || e.Value() != 6 {
+		t.Fatalf("got %v, want 6", e)
+	}
+	l.Tail().RemoveMark()
+	if e := l.FindLast(even); e == nil || e.Value() != 4 {
+		t.Fatalf("after marking 6: got %v, want 4", e)
+	}
+	if e := l.FindLast(func(v interface{}) bool { return v == 7 }); e != nil {
+		t.Fatalf("got %v, want nil", e)
+	}
+}
+
+func TestSwapValues(t *testing.T) {
+	l := ints(3)
+	a, b := l.Head(), l.Tail()
+	SwapValues(a, b)
+	if l.Head() != a || l.Tail() != b {
+		t.Fatalf("elements moved")
+	}
+	checkOrder(t, l, "[3 2 1]")
+	SwapValues(a, a)
+	/* Opposite orders at once mustn't deadlock. */
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() { defer wg.Done(); SwapValues(a, b) }()
+		go func() { defer wg.Done(); SwapValues(b, a) }()
+	}
+	wg.Wait()
+	checkOrder(t, l, "[3 2 1]")
+
+	/* Nor may swaps and insertions or removals next to the elements.
+	Inserting after b locks b then a, so that's out of address order if b
+	is the higher-addressed one. */
+	l = ints(2)
+	a, b = l.Head(), l.Tail()
+	if uintptr(unsafe.Pointer(b)) < uintptr(unsafe.Pointer(a)) {
+		a, b = b, a
+	}
+	if l.Head() != b {
+		b.MoveToFront()
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				SwapValues(a, b)
+			}
+		}
+	}()
+	go func() {
+		defer close(done)
+		for i := 0; i < 50*bulk; i++ {
+			b.InsertAfter(i).Remove()
+			a.InsertBefore(i).Remove()
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatalf("deadlocked")
+	}
+	close(stop)
+	if err := l.Validate(); err != nil || l.Len() != 2 {
+		t.Fatalf("%v (Len %v)", err, l.Len())
+	}
+
+	/* Elements in different lists swap too. */
+	x, y := ints(1), FromSlice([]interface{}{"y"})
+	SwapValues(x.Head(), y.Head())
+	if forward(x) != "[y]" || forward(y) != "[1]" {
+		t.Fatalf("cross-list swap: got %v and %v", forward(x), forward(y))
+	}
+}
+
+func TestFrontBack(t *testing.T) {
+	l := New()
+	if _, ok := l.Front(); ok {
+		t.Fatalf("Front of empty list returned ok")
+	}
+	if _, ok := l.Back(); ok {
+		t.Fatalf("Back of empty list returned ok")
+	}
+	l = ints(4)
+	l.Head().RemoveMark()
+	if v, ok := l.Front(); !ok || v != 2 {
+		t.Fatalf("Front: got %v %v, want 2", v, ok)
+	}
+	if v, ok := l.Back(); !ok || v != 4 {
+		t.Fatalf("Back: got %v %v, want 4", v, ok)
+	}
+	if l.Len() != 4 {
+		t.Fatalf("Len: got %v, want 4", l.Len())
+	}
+}
+
+func TestDedup(t *testing.T) {
+	l := FromSlice([]interface{}{1, 1, 2, 3, 3, 3})
+	if n := l.Dedup(nil); n != 3 {
+		t.Fatalf("removed %v, want 3", n)
+	}
+	checkOrder(t, l, "[1 2 3]")
+	s := FromSlice([]interface{}{"a", "A", "b", "B", "a"})
+	n := s.Dedup(func(a, b interface{}) bool {
+		return strings.EqualFold(a.(string), b.(string))
+	})
+	if n != 3 {
+		t.Fatalf("custom eq: removed %v, want 3", n)
+	}
+	checkOrder(t, s, "[a b]")
+	/* Values which can't be map keys work with an eq. */
+	u := FromSlice([]interface{}{[]int{1}, []int{1}, []int{2}})
+	if n := u.Dedup(func(a, b interface{}) bool {
+		return reflect.DeepEqual(a, b)
+	}); n != 1 {
+		t.Fatalf("unhashable values: removed %v, want 1", n)
+	}
+	checkOrder(t, u, "[[1] [2]]")
+}
+
+func TestPartition(t *testing.T) {
+	l := ints(6)
+	even, odd := l.Partition(func(v interface{}) bool { return v.(int)%2 == 0 })
+	checkOrder(t, even, "[2 4 6]")
+	checkOrder(t, odd, "[1 3 5]")
+	checkOrder(t, l, "[1 2 3 4 5 6]")
+}
+
+func TestWithLock(t *testing.T) {
+	e := New().Append(0)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.WithLock(func(v interface{}, _ bool) interface{} {
+				return v.(int) + 1
+			})
+		}()
+	}
+	wg.Wait()
+	e.RemoveMark()
+	e.WithReadLock(func(v interface{}, marked bool) {
+		if v != 50 || !marked {
+			t.Fatalf("got %v %v, want 50 true", v, marked)
+		}
+	})
+}
+
+func TestRLockAll(t *testing.T) {
+	l := ints(100)
+	h := l.Head()
+	unlock := l.RLockAll()
+	/* Try to change the list while it's locked. */
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			l.Append(i)
+			l.Head().Remove()
+		}
+	}()
+	var first, second []interface{}
+	for e := h; e != nil; e = e.Next() {
+		first = append(first, e.Value())
+	}
+	time.Sleep(10 * time.Millisecond)
+	for e := h; e != nil; e = e.Next() {
+		second = append(second, e.Value())
+	}
+	if len(first) != 100 || fmt.Sprint(first) != fmt.Sprint(second) {
+		t.Fatalf("list changed while locked")
+	}
+	unlock()
+	<-done
+	if l.Len() != 100 {
+		t.Fatalf("Len after unlocking: got %v, want 100", l.Len())
+	}
+}
+
+func TestElementIndex(t *testing.T) {
+	l := ints(4)
+	h, m, last := l.Head(), l.Get(2), l.Tail()
+	if h.Index() != 0 || m.Index() != 2 {
+		t.Fatalf("got %v and %v, want 0 and 2", h.Index(), m.Index())
+	}
+	l.Get(1).RemoveMark()
+	if m.Index() != 1 {
+		t.Fatalf("after marking: got %v, want 1", m.Index())
+	}
+	last.Remove()
+	if last.Index() != -1 {
+		t.Fatalf("removed: got %v, want -1", last.Index())
+	}
+}
+
+func TestReplaceAll(t *testing.T) {
+	l := FromSlice([]interface{}{1, 2, 3, 2})
+	if n := l.ReplaceAll(2, 20, nil); n != 2 {
+		t.Fatalf("replaced %v, want 2", n)
+	}
+	checkOrder(t, l, "[1 20 3 20]")
+	if n := l.ReplaceAll(9, 0, nil); n != 0 {
+		t.Fatalf("replaced %v absent values", n)
+	}
+}
+
+func TestForEachReverse(t *testing.T) {
+	l := ints(5)
+	l.Get(3).RemoveMark()
+	stop := errors.New("stop")
+	var seen []interface{}
+	err := l.ForEachReverse(func(v interface{}) error {
+		seen = append(seen, v)
+		if v == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Fatalf("got error %v, want %v", err, stop)
+	}
+	if got := fmt.Sprint(seen); got != "[5 3 2]" {
+		t.Fatalf("visited %v, want [5 3 2]", got)
+	}
+	/* As with ForEach, fn may remove the element it's visiting. */
+	l = ints(4)
+	seen = nil
+	if err := l.ForEachReverse(func(v interface{}) error {
+		seen = append(seen, v)
+		l.PopBack()
+		return nil
+	}); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	if got := fmt.Sprint(seen); got != "[4 3 2 1]" || l.Len() != 0 {
+		t.Fatalf("visited %v, left %v, want [4 3 2 1] and []", got, forward(l))
+	}
+}
+
+func TestTakeDrop(t *testing.T) {
+	l := ints(4)
+	checkOrder(t, l.Take(2), "[1 2]")
+	checkOrder(t, l.Drop(2), "[3 4]")
+	checkOrder(t, l.Take(9), "[1 2 3 4]")
+	checkOrder(t, l.Drop(9), "[]")
+	checkOrder(t, l.Take(-1), "[]")
+	checkOrder(t, l.Drop(-1), "[1 2 3 4]")
+	checkOrder(t, l, "[1 2 3 4]")
+}
+
+func TestChunk(t *testing.T) {
+	cs := ints(5).Chunk(2)
+	var got []string
+	for _, c := range cs {
+		got = append(got, forward(c))
+	}
+	if fmt.Sprint(got) != "[[1 2] [3 4] [5]]" {
+		t.Fatalf("got %v, want [[1 2] [3 4] [5]]", got)
+	}
+	if cs := ints(3).Chunk(0); len(cs) != 1 || forward(cs[0]) != "[1 2 3]" {
+		t.Fatalf("size 0: got %v", cs)
+	}
+	if cs := New().Chunk(2); len(cs) != 0 {
+		t.Fatalf("empty list: got %v chunks", len(cs))
+	}
+}
+
+func TestGetOrAppend(t *testing.T) {
+	type conn struct {
+		addr string
+		id   int
+	}
+	l := New()
+	keyOf := func(v interface{}) interface{} { return v.(*conn).addr }
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		created int
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, ok := l.GetOrAppend("a", keyOf, func() interface{} {
+				return &conn{"a", i}
+			})
+			if ok {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if created != 1 || l.Len() != 1 {
+		t.Fatalf("created %v, Len %v, want 1 and 1", created, l.Len())
+	}
+	e, ok := l.GetOrAppend("b", keyOf, func() interface{} { return &conn{"b", 0} })
+	if !ok || e != l.Tail() || l.Len() != 2 {
+		t.Fatalf("second key not appended")
+	}
+}
+
+func TestRemoveDetaches(t *testing.T) {
+	l := ints(3)
+	e := l.Head().Next()
+	e.Remove()
+	if e.Next() != nil || e.Prev() != nil {
+		t.Fatalf("removed element still has neighbors")
+	}
+	/* Double-remove stays safe. */
+	e.Remove()
+	checkOrder(t, l, "[1 3]")
+	if l.Len() != 2 {
+		t.Fatalf("Len: got %v, want 2", l.Len())
+	}
+
+	/* Traversals carry on past an element removed from under them. */
+	l = ints(5)
+	var got []interface{}
+	l.Range(func(e *Element) bool {
+		got = append(got, e.Value())
+		e.Remove()
+		return true
+	})
+	if fmt.Sprint(got) != "[1 2 3 4 5]" || l.Len() != 0 {
+		t.Fatalf("Range: got %v (Len %v), want [1 2 3 4 5]", got, l.Len())
+	}
+	l = ints(4)
+	got = nil
+	l.Get(1).RemoveMark()
+	it := l.Iterator()
+	for it.Next() {
+		got = append(got, it.Value())
+	}
+	if fmt.Sprint(got) != "[1 3 4]" || it.Err() != nil {
+		t.Fatalf("Iterator: got %v (%v), want [1 3 4]", got, it.Err())
+	}
+
+	/* RemoveMarked still gets everything. */
+	l = ints(bulk)
+	for e := l.Head(); e != nil; e = e.Next() {
+		e.RemoveMark()
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.RemoveMarked()
+		}()
+	}
+	wg.Wait()
+	if l.Len() != 0 {
+		t.Fatalf("RemoveMarked: Len %v, want 0", l.Len())
+	}
+}
+
+func TestMoveBeforeAfter(t *testing.T) {
+	l := ints(4)
+	l.Tail().MoveBefore(l.Head())
+	checkOrder(t, l, "[4 1 2 3]")
+	l.Head().MoveAfter(l.Tail())
+	checkOrder(t, l, "[1 2 3 4]")
+	a, c := l.Head(), l.Head().Next().Next()
+	a.MoveAfter(c)
+	checkOrder(t, l, "[2 3 1 4]")
+	c.MoveBefore(l.Head())
+	checkOrder(t, l, "[3 2 1 4]")
+	if l.Len() != 4 {
+		t.Fatalf("Len: got %v, want 4", l.Len())
+	}
+	/* No-ops. */
+	a.MoveBefore(a)
+	a.MoveAfter(l.Head().Next())
+	a.MoveBefore(l.Tail())
+	l.Tail().MoveBefore(ints(1).Head())
+	checkOrder(t, l, "[3 2 1 4]")
+	l.Tail().Remove()
+	a.MoveAfter(c)
+	checkOrder(t, l, "[3 1 2]")
+}
+
+func TestRing(t *testing.T) {
+	l := NewRing(3)
+	for i := 1; i <= 5; i++ {
+		if l.Append(i) == nil {
+			t.Fatalf("Append(%v) returned nil", i)
+		}
+	}
+	checkOrder(t, l, "[3 4 5]")
+	if l.Len() != 3 {
+		t.Fatalf("Len: got %v, want 3", l.Len())
+	}
+	if _, v, ok := l.PushRing(6); !ok || v != 3 {
+		t.Fatalf("PushRing: got %v, %v, want 3, true", v, ok)
+	}
+	checkOrder(t, l, "[4 5 6]")
+	if l.PushFront(0) != nil {
+		t.Fatalf("PushFront on full ring didn't fail")
+	}
+	if c := l.Clone(); c.Append(7) == nil || forward(c) != "[5 6 7]" {
+		t.Fatalf("Clone: got %v, want [5 6 7]", forward(c))
+	}
+
+	/* PushRing evicts from any full bounded list. */
+	b := NewBounded(2)
+	b.Append(1)
+	if _, _, ok := b.PushRing(2); ok {
+		t.Fatalf("PushRing evicted from non-full list")
+	}
+	if _, err := b.TryAppend(3); err != ErrFull {
+		t.Fatalf("TryAppend: got %v, want ErrFull", err)
+	}
+	if _, v, ok := b.PushRing(3); !ok || v != 1 {
+		t.Fatalf("PushRing: got %v, %v, want 1, true", v, ok)
+	}
+	checkOrder(t, b, "[2 3]")
+
+	/* Unbounded rings never evict. */
+	u := NewRing(0)
+	for i := 0; i < 10; i++ {
+		if _, _, ok := u.PushRing(i); ok {
+			t.Fatalf("unbounded ring evicted")
+		}
+	}
+	if u.Len() != 10 {
+		t.Fatalf("Len: got %v, want 10", u.Len())
+	}
+
+	/* Concurrent appends keep the bound. */
+	r := NewRing(10)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.Append(j)
+			}
+		}()
+	}
+	wg.Wait()
+	if r.Len() != 10 {
+		t.Fatalf("concurrent Len: got %v, want 10", r.Len())
+	}
+}
+
+func TestVersion(t *testing.T) {
+	l := ints(3)
+	v := l.Version()
+	l.Head().RemoveMark()
+	l.Head().SetValue(9)
+	if l.Version() != v {
+		t.Fatalf("marking or setting a value changed the version")
+	}
+	for i, f := range []func(){
+		func() { l.Append(4) },
+		func() { l.Head().Remove() },
+		func() { l.Reverse() },
+		func() { l.Rotate(1) },
+		func() { l.Sort(func(a, b interface{}) bool { return a.(int) < b.(int) }) },
+		func() { l.Head().MoveToBack() },
+		func() { l.Splice(l.Head(), l.Head(), l.Tail()) },
+		func() { l.RemoveMarked() },
+		func() { l.Clear() },
+	} {
+		f()
+		if n := l.Version(); n <= v {
+			t.Fatalf("change %v: version %v not past %v", i, n, v)
+		} else {
+			v = n
+		}
+	}
+
+	/* Mutating mid-iteration trips the check. */
+	l = ints(4)
+	var got []interface{}
+	it := l.Iterator()
+	for it.Next() {
+		got = append(got, it.Value())
+		if it.Value() == 2 {
+			l.Append(5)
+		}
+	}
+	if fmt.Sprint(got) != "[1 2]" || it.Err() != ErrModified {
+		t.Fatalf("got %v (%v), want [1 2] (%v)", got, it.Err(), ErrModified)
+	}
+	if it.Next() {
+		t.Fatalf("Next after ErrModified returned true")
+	}
+	it = l.Iterator()
+	l.Head().Remove()
+	if it.Next() || it.Err() != ErrModified {
+		t.Fatalf("change before first Next not caught")
+	}
+}
+
+func TestSame(t *testing.T) {
+	l := FromSlice([]interface{}{1, 1})
+	a, b := l.Head(), l.Tail()
+	if !a.Same(a) || a.Same(b) {
+		t.Fatalf("identity wrong")
+	}
+	if a.Same(nil) || (*Element)(nil).Same(a) || !(*Element)(nil).Same(nil) {
+		t.Fatalf("nil handling wrong")
+	}
+	a.Remove()
+	if !a.Same(a) || a.Same(b) {
+		t.Fatalf("identity wrong after Remove")
+	}
+}
+
+func TestFilterInPlace(t *testing.T) {
+	l := ints(6)
+	four := l.Get(3)
+	l.Head().RemoveMark()
+	if n := l.FilterInPlace(func(v interface{}) bool {
+		return v.(int)%2 == 0
+	}); n != 2 {
+		t.Fatalf("removed %v, want 2", n)
+	}
+	checkOrder(t, l, "[2 4 6]")
+	if four.Value() != 4 || four.Index() != 1 {
+		t.Fatalf("kept element invalid")
+	}
+	l = ints(6)
+	if n := l.FilterInPlace(func(v interface{}) bool {
+		return v.(int)%2 == 0
+	}); n != 3 {
+		t.Fatalf("removed %v, want 3", n)
+	}
+	checkOrder(t, l, "[2 4 6]")
+}
+
+func TestOnChange(t *testing.T) {
+	l := New()
+	var evs []ChangeEvent
+	l.OnChange(func(ev ChangeEvent) {
+		evs = append(evs, ev)
+		/* Hooks may use the list. */
+		l.Len()
+	})
+	n := 0
+	l.OnChange(func(ChangeEvent) { n++ })
+	a := l.Append(1)
+	l.Append(2)
+	l.PushFront(0)
+	a.Remove()
+	l.Head().MoveToBack()
+	l.Reverse()
+	l.Head().RemoveMark()
+	l.Head().SetValue(5)
+	l.Clear()
+	want := []ChangeEvent{
+		{ChangeInsert, 1},
+		{ChangeInsert, 2},
+		{ChangeInsert, 0},
+		{ChangeRemove, 1},
+		{ChangeMove, 0},
+		{ChangeReorder, nil},
+		{ChangeRemove, 0},
+		{ChangeRemove, 5},
+	}
+	if fmt.Sprint(evs) != fmt.Sprint(want) {
+		t.Fatalf("got %v, want %v", evs, want)
+	}
+	if n != len(want) {
+		t.Fatalf("second hook called %v times, want %v", n, len(want))
+	}
+
+	/* Hooks which change the list see their own changes in order. */
+	l = New()
+	evs = nil
+	l.OnChange(func(ev ChangeEvent) {
+		evs = append(evs, ev)
+		if ev.Kind == ChangeInsert && ev.Value.(int) < 3 {
+			l.Append(ev.Value.(int) + 1)
+		}
+	})
+	l.Append(1)
+	if fmt.Sprint(evs) != "[{0 1} {0 2} {0 3}]" {
+		t.Fatalf("reentrant: got %v", evs)
+	}
+
+	/* A mirror kept by a hook ends up matching the list. */
+	l = New()
+	var (
+		mu     sync.Mutex
+		mirror = make(map[interface{}]int)
+	)
+	l.OnChange(func(ev ChangeEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch ev.Kind {
+		case ChangeInsert:
+			mirror[ev.Value]++
+		case ChangeRemove:
+			if mirror[ev.Value]--; mirror[ev.Value] == 0 {
+				delete(mirror, ev.Value)
+			}
+		}
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				e := l.Append(i*100 + j)
+				if j%2 == 0 {
+					e.Remove()
+				}
+			}
+		}(i)
+	}
+	/* Whoever's firing when a change is made delivers its event
+	before returning, so by now everything's been delivered. */
+	wg.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(mirror) != l.Len() {
+		t.Fatalf("mirror has %v values, list has %v", len(mirror), l.Len())
+	}
+	for _, v := range l.ToSlice() {
+		if mirror[v] != 1 {
+			t.Fatalf("mirror missing %v", v)
+		}
+	}
+}
+
+func TestRemoveElements(t *testing.T) {
+	l := ints(6)
+	es := []*Element{l.Get(0), l.Get(2), l.Get(5)}
+	es[1].Remove()
+	es = append(es, es[0], nil, ints(1).Head())
+	if n := l.RemoveElements(es); n != 2 {
+		t.Fatalf("removed %v, want 2", n)
+	}
+	checkOrder(t, l, "[2 4 5]")
+	if l.Len() != 3 {
+		t.Fatalf("Len: got %v, want 3", l.Len())
+	}
+	var all []*Element
+	for e := l.Head(); e != nil; e = e.Next() {
+		all = append(all, e)
+	}
+	all[1].RemoveMark()
+	if n := l.RemoveElements(all); n != 3 || l.Len() != 0 || l.LiveLen() != 0 {
+		t.Fatalf("removed %v (Len %v), want 3 (Len 0)", n, l.Len())
+	}
+	checkOrder(t, l, "[]")
+	l.Append(1)
+	checkOrder(t, l, "[1]")
+}
+
+/* bulkElements returns a list of bulk elements and the elements themselves. */
+func bulkElements() (*List, []*Element) {
+	l := New()
+	es := make([]*Element, bulk)
+	for i := range es {
+		es[i] = l.Append(i)
+	}
+	return l, es
+}
+
+func BenchmarkRemoveElements(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		l, es := bulkElements()
+		b.StartTimer()
+		l.RemoveElements(es)
+	}
+}
+
+func BenchmarkRemoveLoop(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		_, es := bulkElements()
+		b.StartTimer()
+		for _, e := range es {
+			e.Remove()
+		}
+	}
+}
+
+func TestAppendWithInfo(t *testing.T) {
+	l := NewBounded(3)
+	for i := 0; i < 3; i++ {
+		if e, first := l.AppendWithInfo(i); e == nil || first != (i == 0) {
+			t.Fatalf("append %v: got %v, %v", i, e, first)
+		}
+	}
+	if e, first := l.AppendWithInfo(3); e != nil || first {
+		t.Fatalf("full: got %v, %v, want nil, false", e, first)
+	}
+	l.Clear()
+	if _, first := l.AppendWithInfo(4); !first {
+		t.Fatalf("append after Clear not first")
+	}
+	checkOrder(t, l, "[4]")
+	if _, first := NewRing(1).AppendWithInfo(5); !first {
+		t.Fatalf("first append to ring not first")
+	}
+	r := NewRing(1)
+	r.Append(5)
+	if _, first := r.AppendWithInfo(6); first {
+		t.Fatalf("evicting append to ring was first")
+	}
+
+	/* Exactly one of many concurrent appenders sees the list empty. */
+	l = New()
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		firsts int
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, first := l.AppendWithInfo(i); first {
+				mu.Lock()
+				firsts++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if firsts != 1 {
+		t.Fatalf("%v appenders saw an empty list, want 1", firsts)
+	}
+}
+
+func TestSetValueIf(t *testing.T) {
+	e := New().Append(0)
+	if e.SetValueIf(1, 2, nil) || e.Value() != 0 {
+		t.Fatalf("set with wrong expected value")
+	}
+	if !e.SetValueIf(0, 1, nil) || e.Value() != 1 {
+		t.Fatalf("didn't set with right expected value")
+	}
+	eq := func(a, b interface{}) bool { return fmt.Sprint(a) == fmt.Sprint(b) }
+	if !e.SetValueIf("1", 2, eq) || e.Value() != 2 {
+		t.Fatalf("custom eq not used")
+	}
+
+	/* Only one CAS wins per expected value, so retrying counts right. */
+	e.SetValue(0)
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		wins int
+	)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < bulk; j++ {
+				for {
+					v := e.Value().(int)
+					if e.SetValueIf(v, v+1, nil) {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if e.Value() != 2*bulk {
+		t.Fatalf("got %v, want %v", e.Value(), 2*bulk)
+	}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if e.SetValueIf(2*bulk, -1, nil) {
+				mu.Lock()
+				wins++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if wins != 1 {
+		t.Fatalf("%v CASes won, want 1", wins)
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	l := ints(6)
+	mid := l.Get(2)
+	l.Get(3).RemoveMark()
+	if e := mid.Advance(2); e == nil || e.Value() != 6 {
+		t.Fatalf("Advance(2): got %v, want 6", e)
+	}
+	if e := mid.Advance(-1); e == nil || e.Value() != 2 {
+		t.Fatalf("Advance(-1): got %v, want 2", e)
+	}
+	if mid.Advance(0) != mid {
+		t.Fatalf("Advance(0) moved")
+	}
+	if mid.Advance(3) != nil || mid.Advance(-3) != nil {
+		t.Fatalf("running off the end didn't give nil")
+	}
+	if e := l.Tail().Advance(-4); e != l.Head() {
+		t.Fatalf("Advance(-4) from tail: got %v, want head", e)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	l := ints(6)
+	var es []*Element
+	for e := l.Head(); e != nil; e = e.Next() {
+		es = append(es, e)
+	}
+	for _, i := range []int{0, 2, 3, 5} {
+		es[i].RemoveMark()
+	}
+	if n := l.Compact(); n != 4 {
+		t.Fatalf("compacted %v, want 4", n)
+	}
+	checkOrder(t, l, "[2 5]")
+	if l.Len() != 2 || l.LiveLen() != 2 {
+		t.Fatalf("Len %v, LiveLen %v, want 2", l.Len(), l.LiveLen())
+	}
+	if es[1].Next() != es[4] || es[4].Prev() != es[1] {
+		t.Fatalf("survivors not linked")
+	}
+	if l.Compact() != 0 {
+		t.Fatalf("second Compact removed something")
+	}
+	es[1].RemoveMark()
+	es[4].RemoveMark()
+	if n := l.Compact(); n != 2 || l.Len() != 0 || !l.IsEmpty() {
+		t.Fatalf("compacting everything: %v removed, Len %v", n, l.Len())
+	}
+	l.Append(7)
+	checkOrder(t, l, "[7]")
+}
+
+/* markedList returns a list of 10k elements, all but every hundredth marked. */
+func markedList() *List {
+	l := New()
+	for i := 0; i < 10000; i++ {
+		e := l.Append(i)
+		if i%100 != 0 {
+			e.RemoveMark()
+		}
+	}
+	return l
+}
+
+func BenchmarkCompact(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		l := markedList()
+		b.StartTimer()
+		l.Compact()
+	}
+}
+
+func BenchmarkRemoveMarked(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		l := markedList()
+		b.StartTimer()
+		l.RemoveMarked()
+	}
+}
+
+func TestRangeMutable(t *testing.T) {
+	l := ints(6)
+	var seen []interface{}
+	i := 0
+	l.RangeMutable(func(e *Element) (bool, bool) {
+		seen = append(seen, e.Value())
+		i++
+		return i%2 == 1, false
+	})
+	if fmt.Sprint(seen) != "[1 2 3 4 5 6]" {
+		t.Fatalf("visited %v, want [1 2 3 4 5 6]", seen)
+	}
+	checkOrder(t, l, "[2 4 6]")
+	if l.Len() != 3 {
+		t.Fatalf("Len: got %v, want 3", l.Len())
+	}
+	l.RangeMutable(func(e *Element) (bool, bool) {
+		return true, e.Value() == 4
+	})
+	checkOrder(t, l, "[6]")
+	/* fn may remove things itself. */
+	l = ints(4)
+	l.RangeMutable(func(e *Element) (bool, bool) {
+		if e.Value() == 2 {
+			l.Tail().Remove()
+		}
+		return e.Value() == 1, false
+	})
+	checkOrder(t, l, "[2 3]")
+}
+
+func TestMoveToList(t *testing.T) {
+	pending, running := ints(3), New()
+	e := pending.Get(1)
+	e.MoveToList(running)
+	if e.Value() != 2 || e.List() != running {
+		t.Fatalf("moved element has value %v, list %p", e.Value(), e.List())
+	}
+	if pending.Len() != 2 || running.Len() != 1 {
+		t.Fatalf("Len: got %v and %v, want 2 and 1", pending.Len(), running.Len())
+	}
+	checkOrder(t, pending, "[1 3]")
+	checkOrder(t, running, "[2]")
+	pending.Head().MoveToList(running)
+	checkOrder(t, running, "[2 1]")
+	if running.Tail().Prev() != e {
+		t.Fatalf("moved element lost its identity")
+	}
+
+	/* It's then a normal member of its new list. */
+	e.MoveToBack()
+	checkOrder(t, running, "[1 2]")
+	if e.Index() != 1 {
+		t.Fatalf("Index: got %v, want 1", e.Index())
+	}
+	e.Remove()
+	checkOrder(t, running, "[1]")
+	checkOrder(t, pending, "[3]")
+	if running.Len() != 1 || pending.Len() != 1 {
+		t.Fatalf("Len after Remove: got %v and %v, want 1 and 1", running.Len(), pending.Len())
+	}
+
+	/* Removed elements and full lists are left alone, and moving within
+	a list is MoveToBack. */
+	e.MoveToList(pending)
+	full := NewBounded(1)
+	full.Append(0)
+	pending.Head().MoveToList(full)
+	checkOrder(t, pending, "[3]")
+	checkOrder(t, full, "[0]")
+	running.Append(4)
+	running.Head().MoveToList(running)
+	checkOrder(t, running, "[4 1]")
+
+	/* Marks move with the element. */
+	m := running.Tail()
+	m.RemoveMark()
+	m.MoveToList(pending)
+	if _, marked, _ := running.Stats(); marked != 0 {
+		t.Fatalf("source still counts the mark")
+	}
+	if _, marked, _ := pending.Stats(); marked != 1 {
+		t.Fatalf("destination doesn't count the mark")
+	}
+	if pending.Compact() != 1 {
+		t.Fatalf("mark lost")
+	}
+
+	/* Shuffling both ways at once doesn't deadlock or lose anything. */
+	a, b := ints(bulk), New()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			src, dst := a, b
+			if i%2 == 1 {
+				src, dst = b, a
+			}
+			for j := 0; j < bulk; j++ {
+				if e := src.Head(); e != nil {
+					e.MoveToList(dst)
+				}
+				/* Only re-add what we actually removed. */
+				if e := dst.Tail(); e != nil &&
+					e.CompareAndRemove(e.Value(), nil) {
+					dst.Append(e.Value())
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := a.Len() + b.Len(); n != bulk {
+		t.Fatalf("lists hold %v elements, want %v", n, bulk)
+	}
+}
+
+func TestToMap(t *testing.T) {
+	type user struct {
+		ID   int
+		Name string
+	}
+	l := FromSlice([]interface{}{
+		user{1, "a"},
+		user{2, "b"},
+		user{1, "c"},
+		user{3, "d"},
+	})
+	l.Tail().RemoveMark()
+	m := l.ToMap(func(v interface{}) interface{} { return v.(user).ID })
+	if len(m) != 2 {
+		t.Fatalf("got %v keys, want 2", len(m))
+	}
+	if u := m[1].(user); u.Name != "c" {
+		t.Fatalf("duplicate key: got %v, want c", u.Name)
+	}
+	if u := m[2].(user); u.Name != "b" {
+		t.Fatalf("got %v, want b", u.Name)
+	}
+	if len(New().ToMap(nil)) != 0 {
+		t.Fatalf("empty list gave a non-empty map")
+	}
+}
+
+func TestFirstLastN(t *testing.T) {
+	l := ints(5)
+	two, three := l.Get(1), l.Get(2)
+	two.RemoveMark()
+	three.RemoveMark()
+	for _, c := range []struct {
+		n           int
+		first, last string
+	}{
+		{0, "[]", "[]"},
+		{-1, "[]", "[]"},
+		{2, "[1 4]", "[4 5]"},
+		{3, "[1 4 5]", "[1 4 5]"},
+		{10, "[1 4 5]", "[1 4 5]"},
+	} {
+		if got := fmt.Sprint(l.FirstN(c.n)); got != c.first {
+			t.Fatalf("FirstN(%v): got %v, want %v", c.n, got, c.first)
+		}
+		if got := fmt.Sprint(l.LastN(c.n)); got != c.last {
+			t.Fatalf("LastN(%v): got %v, want %v", c.n, got, c.last)
+		}
+	}
+	if New().FirstN(3) == nil || New().LastN(3) == nil {
+		t.Fatalf("empty list gave nil")
+	}
+}
+
+func TestAppendWait(t *testing.T) {
+	l := NewBounded(1)
+	if err := l.AppendWait(context.Background(), 1); err != nil {
+		t.Fatalf("AppendWait to empty list: %v", err)
+	}
+	/* The producer blocks until the consumer pops. */
+	popped := make(chan interface{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		v, _ := l.PopFront()
+		popped <- v
+	}()
+	if err := l.AppendWait(context.Background(), 2); err != nil {
+		t.Fatalf("AppendWait: %v", err)
+	}
+	if v := <-popped; v != 1 {
+		t.Fatalf("popped %v, want 1", v)
+	}
+	checkOrder(t, l, "[2]")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if err := l.AppendWait(ctx, 3); err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	checkOrder(t, l, "[2]")
+	/* Rings evict rather than waiting. */
+	r := NewRing(1)
+	r.Append(1)
+	if err := r.AppendWait(ctx, 2); err != nil {
+		t.Fatalf("ring AppendWait: %v", err)
+	}
+	checkOrder(t, r, "[2]")
+
+	/* A bounded blocking queue passes everything through in order. */
+	q := NewBounded(2)
+	done := make(chan []interface{})
+	go func() {
+		var vs []interface{}
+		for len(vs) < 100 {
+			v, err := q.PopFrontWait(context.Background())
+			if err != nil {
+				break
+			}
+			vs = append(vs, v)
+		}
+		done <- vs
+	}()
+	for i := 0; i < 100; i++ {
+		if err := q.AppendWait(context.Background(), i); err != nil {
+			t.Fatalf("AppendWait: %v", err)
+		}
+		if q.Len() > 2 {
+			t.Fatalf("bounded queue grew to %v", q.Len())
+		}
+	}
+	vs := <-done
+	for i, v := range vs {
+		if v != i {
+			t.Fatalf("got %v at %v", v, i)
+		}
+	}
+}
+
+func TestCloneFunc(t *testing.T) {
+	type point struct{ X, Y int }
+	l := FromSlice([]interface{}{&point{1, 2}, &point{3, 4}})
+	c := l.CloneFunc(func(v interface{}) interface{} {
+		p := *v.(*point)
+		return &p
+	})
+	c.Head().Value().(*point).X = 9
+	if l.Head().Value().(*point).X != 1 {
+		t.Fatalf("changing the clone's value changed the original")
+	}
+	if c.Len() != 2 || c.Tail().Value().(*point).Y != 4 {
+		t.Fatalf("clone has wrong contents")
+	}
+	if c.Head() == l.Head() {
+		t.Fatalf("clone shares elements")
+	}
+	/* A nil copyVal is Clone. */
+	s := l.CloneFunc(nil)
+	s.Head().Value().(*point).X = 5
+	if l.Head().Value().(*point).X != 5 {
+		t.Fatalf("nil copyVal copied values")
+	}
+	b := NewBounded(3)
+	b.Append(1)
+	if b.CloneFunc(nil).max != 3 {
+		t.Fatalf("bound not kept")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	/* Lots of different changes leave a valid list. */
+	l := ints(10)
+	for i, f := range []func(){
+		func() { l.Head().Remove() },
+		func() { l.Tail().RemoveMark() },
+		func() { l.Get(3).MoveToFront() },
+		func() { l.Reverse() },
+		func() { l.Rotate(3) },
+		func() { l.Sort(func(a, b interface{}) bool { return a.(int) < b.(int) }) },
+		func() { l.Swap(l.Head(), l.Head().Next()) },
+		func() { l.Compact() },
+		func() { l.Head().MoveToList(New()) },
+		func() { l.Clear() },
+	} {
+		f()
+		if err := l.Validate(); err != nil {
+			t.Fatalf("change %v: %v", i, err)
+		}
+	}
+	if err := New().Validate(); err != nil {
+		t.Fatalf("empty list: %v", err)
+	}
+
+	/* Marking while validating is fine. */
+	l = ints(bulk)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := l.Head(); e != nil; e = e.Next() {
+			e.RemoveMark()
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		if err := l.Validate(); err != nil {
+			t.Fatalf("while marking: %v", err)
+		}
+	}
+	<-done
+
+	/* Deliberately broken lists are caught. */
+	for name, corrupt := range map[string]func(l *List){
+		"size":      func(l *List) { l.size++ },
+		"head.prev": func(l *List) { l.head.prev = l.tail },
+		"tail.next": func(l *List) { l.tail.next = l.head },
+		"back link": func(l *List) { l.head.next.next.prev = l.head },
+		"list":      func(l *List) { l.head.next.l = New() },
+		"removed":   func(l *List) { l.tail.removed = true },
+		"tail":      func(l *List) { l.tail = l.tail.prev },
+		"loop":      func(l *List) { l.head.next.next = l.head },
+		"no tail":   func(l *List) { l.tail = nil },
+	} {
+		l := ints(4)
+		corrupt(l)
+		if err := l.Validate(); err == nil {
+			t.Fatalf("%v corruption not detected", name)
+		}
+	}
+}
+
+func TestWaitNonEmpty(t *testing.T) {
+	l := New()
+	l.Append(0).RemoveMark()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		l.Append(1)
+	}()
+	if err := l.WaitNonEmpty(context.Background()); err != nil {
+		t.Fatalf("WaitNonEmpty: %v", err)
+	}
+	if v := l.Head().Value(); v != 1 {
+		t.Fatalf("head is %v, want 1", v)
+	}
+	/* A non-empty list doesn't wait, even with a done context. */
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.WaitNonEmpty(ctx); err != nil {
+		t.Fatalf("WaitNonEmpty on non-empty list: %v", err)
+	}
+	l.Clear()
+	if err := l.WaitNonEmpty(ctx); err != context.Canceled {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if err := l.WaitNonEmpty(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestReverseIterator(t *testing.T) {
+	l := ints(5)
+	l.Get(1).RemoveMark()
+	l.Tail().RemoveMark()
+	var vs []interface{}
+	it := l.ReverseIterator()
+	if it.Element() != nil {
+		t.Fatalf("Element before Next not nil")
+	}
+	for it.Next() {
+		vs = append(vs, it.Value())
+	}
+	if got := fmt.Sprint(vs); got != "[4 3 1]" || it.Err() != nil {
+		t.Fatalf("got %v (%v), want [4 3 1]", got, it.Err())
+	}
+	if New().ReverseIterator().Next() {
+		t.Fatalf("Next on an empty list returned true")
+	}
+	it = l.ReverseIterator()
+	it.Next()
+	l.Append(6)
+	if it.Next() || it.Err() != ErrModified {
+		t.Fatalf("change during reverse iteration not caught")
+	}
+}
+
+func TestReplace(t *testing.T) {
+	l := ints(3)
+	for _, c := range []struct {
+		i    int
+		want string
+	}{{1, "[1 b 3]"}, {0, "[a b 3]"}, {2, "[a b c]"}} {
+		e := l.Get(c.i)
+		n := e.Replace(string(rune('a' + c.i)))
+		if n == nil || n == e || n.Index() != c.i {
+			t.Fatalf("Replace(%v): new element wrong", c.i)
+		}
+		if e.List() != nil || e.Next() != nil || e.Prev() != nil {
+			t.Fatalf("Replace(%v): old element not removed", c.i)
+		}
+		checkOrder(t, l, c.want)
+	}
+	if l.Len() != 3 {
+		t.Fatalf("Len: got %v, want 3", l.Len())
+	}
+	/* Marks aren't carried over, and removed elements can't be
+	replaced. */
+	e := l.Head()
+	e.RemoveMark()
+	n := e.Replace("x")
+	if n.ToRemove() || l.LiveLen() != 3 {
+		t.Fatalf("new element marked")
+	}
+	if e.Replace("y") != nil {
+		t.Fatalf("replaced a removed element")
+	}
+	checkOrder(t, l, "[x b c]")
+	/* Full bounded lists can still be replaced into. */
+	b := NewBounded(1)
+	if b.Append(1).Replace(2) == nil {
+		t.Fatalf("Replace in full list failed")
+	}
+	checkOrder(t, b, "[2]")
+}
+
+func TestWalkFrom(t *testing.T) {
+	l := ints(6)
+	walk := func(start *Element) string {
+		var vs []interface{}
+		l.WalkFrom(start, func(e *Element) bool {
+			vs = append(vs, e.Value())
+			return true
+		})
+		return fmt.Sprint(vs)
+	}
+	mid := l.Get(3)
+	if got := walk(mid); got != "[4 5 6]" {
+		t.Fatalf("from 4: got %v, want [4 5 6]", got)
+	}
+	if got := walk(nil); got != "[1 2 3 4 5 6]" {
+		t.Fatalf("from nil: got %v, want [1 2 3 4 5 6]", got)
+	}
+	mid.RemoveMark()
+	if got := walk(mid); got != "[5 6]" {
+		t.Fatalf("from marked 4: got %v, want [5 6]", got)
+	}
+	mid.Remove()
+	if got := walk(mid); got != "[]" {
+		t.Fatalf("from removed 4: got %v, want []", got)
+	}
+	if got := walk(ints(2).Head()); got != "[]" {
+		t.Fatalf("from another list: got %v, want []", got)
+	}
+	/* Checkpointing and resuming. */
+	var stopped *Element
+	l.WalkFrom(nil, func(e *Element) bool {
+		stopped = e
+		return e.Value() != 2
+	})
+	if got := walk(stopped.Next()); got != "[3 5 6]" {
+		t.Fatalf("resumed: got %v, want [3 5 6]", got)
+	}
+}
+
+func TestAppendValues(t *testing.T) {
+	l := ints(2)
+	e := l.AppendValues(3, 4, 5)
+	if e == nil || e != l.Tail() || e.Value() != 5 {
+		t.Fatalf("returned %v, want the tail", e)
+	}
+	if l.Len() != 5 {
+		t.Fatalf("Len: got %v, want 5", l.Len())
+	}
+	checkOrder(t, l, "[1 2 3 4 5]")
+	if l.AppendValues() != nil || l.Len() != 5 {
+		t.Fatalf("appending nothing did something")
+	}
+	b := NewBounded(2)
+	if e := b.AppendValues(1, 2, 3); e == nil || e.Value() != 2 {
+		t.Fatalf("bounded: returned %v, want 2", e)
+	}
+	if b.AppendValues(4) != nil {
+		t.Fatalf("appended to a full list")
+	}
+}
+
+func TestRemoveWhileWalking(t *testing.T) {
+	/* Removing the current element and the next starts again from the
+	head. */
+	l := ints(6)
+	var vs []interface{}
+	l.ForEach(func(v interface{}) error {
+		vs = append(vs, v)
+		l.PopFront()
+		l.PopFront()
+		return nil
+	})
+	if fmt.Sprint(vs) != "[1 3 5]" || l.Len() != 0 {
+		t.Fatalf("ForEach: visited %v, left %v, want [1 3 5] and []", vs, forward(l))
+	}
+	l = ints(6)
+	vs = nil
+	l.ForEachReverse(func(v interface{}) error {
+		vs = append(vs, v)
+		l.PopBack()
+		l.PopBack()
+		return nil
+	})
+	if fmt.Sprint(vs) != "[6 4 2]" || l.Len() != 0 {
+		t.Fatalf("ForEachReverse: visited %v, left %v, want [6 4 2] and []", vs, forward(l))
+	}
+
+	/* FindLast carries on past the element it's looking at. */
+	l = ints(4)
+	if e := l.FindLast(func(v interface{}) bool {
+		if v == 4 {
+			l.PopBack()
+		}
+		return v == 2
+	}); e == nil || e.Value() != 2 {
+		t.Fatalf("FindLast: got %v, want 2", e)
+	}
+
+	/* Advance from a removed element has nowhere to go. */
+	e := l.Head()
+	e.Remove()
+	if e.Advance(1) != nil || e.Advance(-1) != nil || e.Advance(0) != e {
+		t.Fatalf("Advance from a removed element went somewhere")
+	}
+}